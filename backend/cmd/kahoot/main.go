@@ -3,15 +3,24 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/enzofalone/kahoot/internal/auth"
+	"github.com/enzofalone/kahoot/internal/config"
+	handler "github.com/enzofalone/kahoot/internal/handlers"
 	"github.com/enzofalone/kahoot/internal/repo"
 	"github.com/enzofalone/kahoot/internal/ws"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/cors"
 )
 
 func main() {
@@ -23,34 +32,161 @@ func main() {
 	}
 }
 
-// run starts a http.Server for the passed in address
-// with all requests handled by echoServer.
+// run starts a http.Server for the configured address
+// serving the API and the websocket handlers of the game.
 func run() error {
-	if len(os.Args) < 2 {
-		return errors.New("please provide an address to listen on as the first argument")
+	cfg, err := config.Load(os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
 	}
+	if err != nil {
+		return err
+	}
+
+	logger := cfg.Log.NewLogger(os.Stderr)
+	slog.SetDefault(logger)
 
-	log.Printf("connecting to database")
-	db := repo.NewDatabase("./db.sqlite", 10)
+	logger.Info("connecting to database")
+	db := repo.NewDatabase(cfg.Database.URL, cfg.Database.MaxConns)
+	if err := db.Connect(context.Background()); err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	applied, err := db.Migrate(context.Background())
+	if err != nil {
+		return err
+	}
+	if applied > 0 {
+		logger.Info("migrated database schema", "migrations", applied)
+	}
 
-	l, err := net.Listen("tcp", os.Args[1])
+	l, err := net.Listen("tcp", cfg.Server.Addr)
 	if err != nil {
 		return err
 	}
-	log.Printf("listening on ws://%v", l.Addr())
+	logger.Info("listening", "addr", "ws://"+l.Addr().String())
+
+	codes := ws.RoomCodeFormat{Kind: cfg.Rooms.CodeFormat, Length: cfg.Rooms.CodeLength}
+	if codes.Length == 0 {
+		codes.Length = ws.DefaultRoomCodeFormat().Length
+		if codes.Kind == ws.ROOM_CODE_WORDS {
+			codes.Length = 4
+		}
+	}
+	if err := codes.Validate(); err != nil {
+		return err
+	}
 
 	// TODO: temporary thing, use redis or something better
-	rooms := make(map[string]*ws.Room)
+	rooms := ws.NewRooms(codes.MaxRooms())
 
-	playerHandler := ws.NewPlayerHandler(log.Printf, rooms)
-	hostHandler := ws.NewHostHandler(log.Printf, db, rooms)
+	nicknames := &ws.NicknamePolicy{
+		MinLength:    cfg.Rooms.NicknameMinLength,
+		MaxLength:    cfg.Rooms.NicknameMaxLength,
+		BlockedWords: []string{},
+	}
+	if len(cfg.Rooms.BlockedWords) > 0 {
+		words, err := ws.LoadBlockedWords(cfg.Rooms.BlockedWords)
+		if err != nil {
+			return err
+		}
+		nicknames.BlockedWords = words
+	}
+
+	limits := ws.Limits{
+		MaxPlayersPerRoom: cfg.Limits.MaxPlayersPerRoom,
+		MaxPlayers:        cfg.Limits.MaxPlayers,
+
+		MaxSpectatorsPerRoom: cfg.Limits.MaxSpectatorsPerRoom,
+
+		MaxMessageSize: cfg.Limits.MaxMessageSize,
+		PlayerEvents:   ws.RateLimit(cfg.Limits.PlayerEvents),
+		HostEvents:     ws.RateLimit(cfg.Limits.HostEvents),
+
+		SendQueue:    cfg.Limits.SendQueue,
+		WriteTimeout: cfg.Limits.WriteTimeout,
+		PingInterval: cfg.Limits.PingInterval,
+		PongTimeout:  cfg.Limits.PongTimeout,
+		ReplayBuffer: cfg.Limits.ReplayBuffer,
+	}
+
+	guard := ws.NewJoinGuard(logger, ws.JoinGuardConfig(cfg.Limits.Joins))
+
+	// cookies are only sent over https unless disabled for local development
+	secureCookies := !cfg.Server.InsecureCookies
+	sessions := auth.NewSessions(db, secureCookies)
+	authHandler := handler.NewAuthHandler(logger, db, sessions)
+	bankHandler := handler.NewBankHandler(logger, db)
+	tokenHandler := handler.NewTokenHandler(logger, db)
+	resultHandler := handler.NewResultHandler(logger, db)
+	orgHandler := handler.NewOrgHandler(logger, db)
+
+	oidcConfig := auth.OIDCConfig(cfg.OIDC)
+
+	var oidcHandler *handler.OIDCHandler
+	if oidcConfig.Enabled() {
+		provider, err := auth.NewOIDC(context.Background(), oidcConfig)
+		if err != nil {
+			return err
+		}
+		oidcHandler = handler.NewOIDCHandler(logger, db, sessions, provider, secureCookies)
+	}
+
+	broadcaster := ws.NewBroadcaster(logger, limits)
+	playerHandler := ws.NewPlayerHandler(logger, cfg, rooms, broadcaster, nicknames, limits, codes, guard)
+	hostHandler := ws.NewHostHandler(logger, cfg, db, rooms, broadcaster, limits, codes, sessions)
+	spectatorHandler := ws.NewSpectatorHandler(logger, cfg, rooms, broadcaster, limits, codes, guard)
+	controllerHandler := ws.NewControllerHandler(logger, cfg, rooms, broadcaster, hostHandler, codes, guard)
+	healthHandler := handler.NewHealthHandler(logger, db, playerHandler.Full)
 
 	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("GET /healthz", healthHandler.Healthz)
+	mux.HandleFunc("GET /readyz", healthHandler.Readyz)
 	mux.HandleFunc("/host", hostHandler.ServeHTTP)
 	mux.HandleFunc("/player", playerHandler.ServeHTTP)
+	mux.HandleFunc("/spectate", spectatorHandler.ServeHTTP)
+	mux.HandleFunc("/controller", controllerHandler.ServeHTTP)
+
+	mux.HandleFunc("POST /auth/register", authHandler.Register)
+	mux.HandleFunc("POST /auth/login", authHandler.Login)
+	mux.HandleFunc("POST /auth/logout", authHandler.Logout)
+	mux.HandleFunc("GET /auth/me", sessions.RequireSession(authHandler.Me))
+	if oidcHandler != nil {
+		mux.HandleFunc("GET /auth/oidc/login", oidcHandler.Login)
+		mux.HandleFunc("GET /auth/oidc/callback", oidcHandler.Callback)
+	}
+
+	mux.HandleFunc("GET /banks", sessions.Require(auth.SCOPE_READ_BANKS, bankHandler.GetBankList))
+	mux.HandleFunc("POST /banks", sessions.Require(auth.SCOPE_WRITE_BANKS, bankHandler.CreateBank))
+	mux.HandleFunc("GET /banks/{id}", sessions.Require(auth.SCOPE_READ_BANKS, bankHandler.GetBank))
+	mux.HandleFunc("PUT /banks/{id}", sessions.Require(auth.SCOPE_WRITE_BANKS, bankHandler.UpdateBank))
+	mux.HandleFunc("DELETE /banks/{id}", sessions.Require(auth.SCOPE_WRITE_BANKS, bankHandler.DeleteBank))
+
+	mux.HandleFunc("GET /orgs", sessions.RequireSession(orgHandler.GetOrganizationList))
+	mux.HandleFunc("POST /orgs", sessions.RequireSession(orgHandler.CreateOrganization))
+	mux.HandleFunc("GET /orgs/{id}/members", sessions.RequireSession(orgHandler.GetMemberList))
+	mux.HandleFunc("PUT /orgs/{id}/members", sessions.RequireSession(orgHandler.SetMember))
+	mux.HandleFunc("DELETE /orgs/{id}/members/{userId}", sessions.RequireSession(orgHandler.RemoveMember))
+
+	mux.HandleFunc("GET /games", sessions.Require(auth.SCOPE_READ_RESULTS, resultHandler.GetGameList))
+	mux.HandleFunc("GET /games/{id}/results", sessions.Require(auth.SCOPE_READ_RESULTS, resultHandler.GetResults))
+
+	// tokens cannot be used to manage tokens
+	mux.HandleFunc("GET /tokens", sessions.RequireSession(tokenHandler.GetTokenList))
+	mux.HandleFunc("POST /tokens", sessions.RequireSession(tokenHandler.CreateToken))
+	mux.HandleFunc("DELETE /tokens/{id}", sessions.RequireSession(tokenHandler.RevokeToken))
+
+	c := cors.New(cors.Options{
+		AllowedOrigins:   cfg.Server.Origins,
+		AllowedMethods:   []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete},
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		AllowCredentials: true,
+	})
 
 	s := http.Server{
-		Handler: mux,
+		Handler: c.Handler(mux),
 	}
 
 	errc := make(chan error, 1)
@@ -59,17 +195,26 @@ func run() error {
 	}()
 
 	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, os.Interrupt)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
 
 	select {
 	case err := <-errc:
-		log.Printf("failed to serve: %v", err)
+		logger.Error("failed to serve", "err", err)
 	case sig := <-sigs:
-		log.Printf("terminating: %v", sig)
+		logger.Info("terminating", "signal", sig)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	// fail readiness first and give load balancers time to notice, so they
+	// stop sending traffic before rooms are closed
+	healthHandler.Drain()
+	time.Sleep(cfg.Server.ReadinessGrace)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownDrain+cfg.Server.ShutdownTimeout)
 	defer cancel()
 
+	// websockets are hijacked connections that s.Shutdown does not wait for,
+	// rooms are told about the restart and closed before the listener goes away
+	hostHandler.Shutdown(ctx, cfg.Server.ShutdownDrain)
+
 	return s.Shutdown(ctx)
 }
@@ -1,5 +1,40 @@
+CREATE TABLE host_user (
+    id SERIAL PRIMARY KEY,
+    email TEXT NOT NULL UNIQUE,
+    password_hash TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE host_identity (
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    user_id INTEGER NOT NULL REFERENCES host_user(id) ON DELETE CASCADE,
+    PRIMARY KEY (issuer, subject)
+);
+
+CREATE TABLE session (
+    token_hash TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES host_user(id) ON DELETE CASCADE,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE organization (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE org_member (
+    org_id INTEGER NOT NULL REFERENCES organization(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES host_user(id) ON DELETE CASCADE,
+    role TEXT NOT NULL,
+    PRIMARY KEY (org_id, user_id)
+);
+
 CREATE TABLE bank (
     id SERIAL PRIMARY KEY,
+    owner_id INTEGER REFERENCES host_user(id) ON DELETE CASCADE,
+    org_id INTEGER REFERENCES organization(id) ON DELETE CASCADE,
     title TEXT NOT NULL
 );
 
@@ -7,8 +42,39 @@ CREATE TABLE question (
     id SERIAL PRIMARY KEY, 
     bank_id	INTEGER,
     prompt TEXT NOT NULL,
-    answer_bank TEXT,
+    answer_bank TEXT[],
+    correct_answer TEXT NOT NULL,
     next_question INTEGER,
     FOREIGN KEY (bank_id) REFERENCES bank(id) ON DELETE CASCADE,
     FOREIGN KEY (next_question) REFERENCES question(id)
-);
\ No newline at end of file
+);
+
+CREATE TABLE game (
+    id SERIAL PRIMARY KEY,
+    owner_id INTEGER REFERENCES host_user(id) ON DELETE CASCADE,
+    bank_id INTEGER REFERENCES bank(id) ON DELETE SET NULL,
+    org_id INTEGER REFERENCES organization(id) ON DELETE SET NULL,
+    room_code TEXT NOT NULL,
+    started_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    finished_at TIMESTAMPTZ
+);
+
+CREATE TABLE game_result (
+    game_id INTEGER NOT NULL REFERENCES game(id) ON DELETE CASCADE,
+    player_id TEXT NOT NULL,
+    nickname TEXT NOT NULL,
+    points INTEGER NOT NULL,
+    team_id TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (game_id, player_id)
+);
+
+CREATE TABLE api_token (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES host_user(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    scopes TEXT[] NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+);
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// OIDCConfig configures login through an OpenID Connect identity provider
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string // callback of this server registered with the provider
+	PostLoginURL string // where hosts are sent once logged in
+}
+
+// Enabled reports whether an identity provider has been configured
+func (c OIDCConfig) Enabled() bool {
+	return len(c.Issuer) > 0
+}
+
+// OIDC runs the authorization code flow with PKCE against a provider
+type OIDC struct {
+	config   OIDCConfig
+	oauth2   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// Identity holds the claims of a verified ID token used to map it to a host account
+type Identity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// NewOIDC discovers the provider's endpoints and keys from its issuer URL
+func NewOIDC(ctx context.Context, config OIDCConfig) (*OIDC, error) {
+	if len(config.ClientID) == 0 || len(config.RedirectURL) == 0 {
+		return nil, errors.New("oidc: client ID and redirect URL are required")
+	}
+
+	provider, err := oidc.NewProvider(ctx, config.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: failed to discover provider: %w", err)
+	}
+
+	return &OIDC{
+		config: config,
+		oauth2: oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			RedirectURL:  config.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID, "email"},
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: config.ClientID}),
+	}, nil
+}
+
+func (o *OIDC) PostLoginURL() string {
+	return o.config.PostLoginURL
+}
+
+// AuthCodeURL returns the provider URL the host is redirected to
+func (o *OIDC) AuthCodeURL(state string, nonce string, codeVerifier string) string {
+	return o.oauth2.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(codeVerifier))
+}
+
+// Exchange trades an authorization code for tokens and verifies the returned ID token
+func (o *OIDC) Exchange(ctx context.Context, code string, nonce string, codeVerifier string) (*Identity, error) {
+	token, err := o.oauth2.Exchange(ctx, code, oauth2.VerifierOption(codeVerifier))
+	if err != nil {
+		return nil, fmt.Errorf("oidc: failed to exchange code: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("oidc: token response has no id_token")
+	}
+
+	idToken, err := o.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: failed to verify id_token: %w", err)
+	}
+
+	if idToken.Nonce != nonce {
+		return nil, errors.New("oidc: id_token nonce does not match")
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("oidc: failed to parse claims: %w", err)
+	}
+
+	return &Identity{
+		Issuer:        idToken.Issuer,
+		Subject:       idToken.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+	}, nil
+}
@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/enzofalone/kahoot/internal/repo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const SESSION_COOKIE = "kahoot_session"
+const SESSION_DURATION = 7 * 24 * time.Hour
+
+// ErrUnauthenticated is returned when a request carries no valid session
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Sessions issues and verifies host session cookies. Only a hash of each
+// token is stored so a leaked database cannot be used to log in.
+type Sessions struct {
+	db     *repo.Database
+	secure bool // only send cookies over https
+}
+
+func NewSessions(db *repo.Database, secure bool) *Sessions {
+	return &Sessions{
+		db:     db,
+		secure: secure,
+	}
+}
+
+// HashPassword hashes a password with bcrypt
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// dummyHash is checked when there is no real hash, so unknown emails take as
+// long to reject as wrong passwords
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("kahoot"), bcrypt.DefaultCost)
+
+// CheckPassword reports whether password matches a bcrypt hash. An empty hash,
+// for unknown users or accounts without a password, never matches but takes as
+// long to check as a real one
+func CheckPassword(hash string, password string) bool {
+	if len(hash) == 0 {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// Create starts a session for a user and sets its cookie on the response
+func (s *Sessions) Create(ctx context.Context, w http.ResponseWriter, userID int) error {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	expiresAt := time.Now().Add(SESSION_DURATION)
+
+	if err := s.db.CreateSession(ctx, hashToken(token), userID, expiresAt); err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     SESSION_COOKIE,
+		Value:    token,
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   s.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// Authenticate returns the caller of a request, identified either by an
+// API token in the Authorization header or by the session cookie
+func (s *Sessions) Authenticate(r *http.Request) (*Principal, error) {
+	if header := r.Header.Get("Authorization"); len(header) > 0 {
+		token, found := strings.CutPrefix(header, "Bearer ")
+		if !found {
+			return nil, ErrUnauthenticated
+		}
+		return s.authenticateToken(r, token)
+	}
+
+	cookie, err := r.Cookie(SESSION_COOKIE)
+	if err != nil {
+		return nil, ErrUnauthenticated
+	}
+
+	user, err := s.db.GetSessionUser(r.Context(), hashToken(cookie.Value))
+	if errors.Is(err, repo.ErrNotFound) {
+		return nil, ErrUnauthenticated
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Principal{User: user}, nil
+}
+
+// Destroy ends the session of a request and clears its cookie
+func (s *Sessions) Destroy(w http.ResponseWriter, r *http.Request) error {
+	if cookie, err := r.Cookie(SESSION_COOKIE); err == nil {
+		if err := s.db.DeleteSession(r.Context(), hashToken(cookie.Value)); err != nil {
+			return err
+		}
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     SESSION_COOKIE,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   s.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+type contextKey struct{}
+
+// WithPrincipal stores the authenticated caller in a context
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, principal)
+}
+
+// PrincipalFrom returns the authenticated caller stored in a context, if any
+func PrincipalFrom(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(contextKey{}).(*Principal)
+	return principal
+}
+
+// UserFrom returns the authenticated user stored in a context, if any
+func UserFrom(ctx context.Context) *repo.User {
+	if principal := PrincipalFrom(ctx); principal != nil {
+		return principal.User
+	}
+	return nil
+}
+
+// Require only lets requests through whose caller is allowed the scope,
+// storing the caller in the request context
+func (s *Sessions) Require(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, err := s.Authenticate(r)
+		if errors.Is(err, ErrUnauthenticated) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		if !principal.Can(scope) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		next(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+	}
+}
+
+// RequireSession only lets requests through that carry a browser session,
+// API tokens are rejected
+func (s *Sessions) RequireSession(next http.HandlerFunc) http.HandlerFunc {
+	return s.Require(SCOPE_SESSION, next)
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/enzofalone/kahoot/internal/repo"
+)
+
+// API token scopes
+const (
+	SCOPE_READ_BANKS   = "banks:read"
+	SCOPE_WRITE_BANKS  = "banks:write"
+	SCOPE_READ_RESULTS = "results:read"
+	SCOPE_HOST_GAMES   = "games:host"
+
+	// only granted to browser sessions, used for actions tokens must not perform such as minting tokens
+	SCOPE_SESSION = "session"
+)
+
+var Scopes = []string{SCOPE_READ_BANKS, SCOPE_WRITE_BANKS, SCOPE_READ_RESULTS, SCOPE_HOST_GAMES}
+
+const API_TOKEN_PREFIX = "kh_"
+
+// Principal is an authenticated caller, either a browser session or an API token
+type Principal struct {
+	User    *repo.User
+	TokenID int      // 0 for browser sessions
+	Scopes  []string // scopes of the API token
+}
+
+// Can reports whether the caller is allowed to act within scope.
+// Browser sessions can do everything, API tokens only what they were created for
+func (p *Principal) Can(scope string) bool {
+	if p.TokenID == 0 {
+		return true
+	}
+	return slices.Contains(p.Scopes, scope)
+}
+
+// ValidScopes reports whether every scope is one tokens can be granted
+func ValidScopes(scopes []string) bool {
+	if len(scopes) == 0 {
+		return false
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(Scopes, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// GenerateAPIToken returns a new token and the hash to store for it
+func GenerateAPIToken() (token string, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	token = API_TOKEN_PREFIX + base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+func (s *Sessions) authenticateToken(r *http.Request, token string) (*Principal, error) {
+	if !strings.HasPrefix(token, API_TOKEN_PREFIX) {
+		return nil, ErrUnauthenticated
+	}
+
+	t, user, err := s.db.UseAPIToken(r.Context(), hashToken(token))
+	if errors.Is(err, repo.ErrNotFound) {
+		return nil, ErrUnauthenticated
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Principal{
+		User:    user,
+		TokenID: t.ID,
+		Scopes:  t.Scopes,
+	}, nil
+}
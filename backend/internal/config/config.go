@@ -0,0 +1,504 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// ENV_PREFIX is prepended to the upper snake case flag name to get its environment variable,
+// e.g. -question-time is read from KAHOOT_QUESTION_TIME
+const ENV_PREFIX = "KAHOOT_"
+
+// environment variables that predate the config package and keep their name
+var envNames = map[string]string{
+	"database-url": "DATABASE_URL",
+}
+
+// Config holds every setting of the server. Settings are read from, in order
+// of precedence: command line flags, environment variables, the config file
+// and the defaults.
+//
+// Secrets, the database URL and the OIDC client secret, must be given through
+// the environment or the config file. Command lines can be read by every user
+// of the machine and end up in process listings and crash reports
+type Config struct {
+	File     string // optional file of "name = value" lines using the flag names
+	Server   Server
+	Log      Log
+	Database Database
+	Game     Game
+	Limits   Limits
+	Rooms    Rooms
+	OIDC     OIDC
+}
+
+type Server struct {
+	Addr            string
+	Origins         []string // allowed browser origins, such as http://localhost:5173
+	ShutdownTimeout time.Duration
+	ShutdownDrain   time.Duration // time running questions get to finish on shutdown, 0 to not wait
+	ReadinessGrace  time.Duration // time load balancers get to notice readiness failing before shutting down
+	InsecureCookies bool          // send session cookies over http, for local development only
+}
+
+// Log formats
+const (
+	LOG_FORMAT_TEXT = "text"
+	LOG_FORMAT_JSON = "json"
+)
+
+type Log struct {
+	Format string
+	Level  slog.Level
+}
+
+type Database struct {
+	URL      string
+	MaxConns int
+}
+
+// Game holds the pacing and scoring of games
+type Game struct {
+	StartDelay       time.Duration // between starting the game and the first prompt
+	PromptDelay      time.Duration // prompt is shown alone before its answers
+	AllAnsweredDelay time.Duration // before revealing once everyone answered
+	QuestionTime     time.Duration // time players have to answer
+	FinishDelay      time.Duration // the room is kept open after the results for this long
+	MaxScore         int           // for an instant correct answer
+	MinScore         int           // for a correct answer at the end of the question time
+}
+
+type Limits struct {
+	MaxPlayersPerRoom    int
+	MaxPlayers           int
+	MaxSpectatorsPerRoom int
+	MaxMessageSize       int64
+	PlayerEvents         RateLimit
+	HostEvents           RateLimit
+	SendQueue            int           // outbound messages buffered per connection before it is dropped
+	WriteTimeout         time.Duration // for a single message to be written to a connection
+	PingInterval         time.Duration // between pings to hosts and players, 0 disables heartbeats
+	PongTimeout          time.Duration // a connection has to answer a ping before it is considered dead
+	ReplayBuffer         int           // events kept per room for clients to catch up after lagging
+	Joins                JoinGuard
+}
+
+// JoinGuard throttles clients guessing room codes, see ws.JoinGuardConfig
+type JoinGuard struct {
+	FreeAttempts    int           // failures allowed per IP before backoff kicks in
+	BaseDelay       time.Duration // first backoff, doubled on every further failure
+	MaxDelay        time.Duration
+	Forget          time.Duration // failures older than this are forgotten
+	SuspiciousAfter int           // failures from one IP before it is logged as suspicious
+	MaxPending      int           // attempts from one IP looking up a room at once, 0 means unlimited
+
+	BreakerThreshold int // failures across the server within BreakerWindow that reject every join, 0 disables it
+	BreakerWindow    time.Duration
+	BreakerCooldown  time.Duration
+}
+
+type RateLimit struct {
+	Every         time.Duration
+	Burst         int
+	MaxViolations int
+	Window        time.Duration
+}
+
+type Rooms struct {
+	CodeFormat        string
+	CodeLength        int    // 0 uses the default length of the format
+	BlockedWords      string // path to a file with one blocked nickname word per line
+	NicknameMinLength int
+	NicknameMaxLength int
+}
+
+type OIDC struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	PostLoginURL string
+}
+
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Addr:            "localhost:8080",
+			Origins:         []string{"http://127.0.0.1:5173", "http://localhost:5173"},
+			ShutdownTimeout: 10 * time.Second,
+			ReadinessGrace:  5 * time.Second,
+		},
+		Log: Log{
+			Format: LOG_FORMAT_TEXT,
+			Level:  slog.LevelInfo,
+		},
+		Database: Database{
+			MaxConns: 10,
+		},
+		Game: Game{
+			StartDelay:       5 * time.Second,
+			PromptDelay:      5 * time.Second,
+			AllAnsweredDelay: 3 * time.Second,
+			QuestionTime:     30 * time.Second,
+			FinishDelay:      30 * time.Second,
+			MaxScore:         1000,
+			MinScore:         100,
+		},
+		Limits: Limits{
+			MaxPlayersPerRoom:    200,
+			MaxPlayers:           5000,
+			MaxSpectatorsPerRoom: 10,
+			MaxMessageSize:       4096,
+			SendQueue:            64,
+			WriteTimeout:         10 * time.Second,
+			PingInterval:         15 * time.Second,
+			PongTimeout:          10 * time.Second,
+			ReplayBuffer:         256,
+			PlayerEvents: RateLimit{
+				Every:         200 * time.Millisecond,
+				Burst:         5,
+				MaxViolations: 20,
+				Window:        10 * time.Second,
+			},
+			HostEvents: RateLimit{
+				Every:         100 * time.Millisecond,
+				Burst:         10,
+				MaxViolations: 50,
+				Window:        10 * time.Second,
+			},
+			Joins: JoinGuard{
+				FreeAttempts:     5,
+				BaseDelay:        time.Second,
+				MaxDelay:         5 * time.Minute,
+				Forget:           15 * time.Minute,
+				SuspiciousAfter:  20,
+				MaxPending:       10,
+				BreakerThreshold: 1000,
+				BreakerWindow:    time.Minute,
+				BreakerCooldown:  30 * time.Second,
+			},
+		},
+		Rooms: Rooms{
+			CodeFormat:        "alphanumeric",
+			NicknameMinLength: 2,
+			NicknameMaxLength: 16,
+		},
+		OIDC: OIDC{
+			PostLoginURL: "http://localhost:5173/",
+		},
+	}
+}
+
+// flagSet binds every setting of c to a flag
+func (c *Config) flagSet(output io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet("kahoot", flag.ContinueOnError)
+	fs.SetOutput(output)
+
+	fs.StringVar(&c.File, "config", c.File, "path to a config file")
+
+	fs.StringVar(&c.Server.Addr, "addr", c.Server.Addr, "address to listen on")
+	fs.Var((*listValue)(&c.Server.Origins), "origins", "comma separated browser origins allowed to connect")
+	fs.DurationVar(&c.Server.ShutdownTimeout, "shutdown-timeout", c.Server.ShutdownTimeout, "time to finish requests on shutdown")
+	fs.DurationVar(&c.Server.ShutdownDrain, "shutdown-drain", c.Server.ShutdownDrain, "time running questions get to finish on shutdown, 0 to not wait")
+	fs.DurationVar(&c.Server.ReadinessGrace, "readiness-grace", c.Server.ReadinessGrace, "time load balancers get to notice readiness failing before shutting down")
+	fs.BoolVar(&c.Server.InsecureCookies, "insecure-cookies", c.Server.InsecureCookies, "send session cookies over http")
+
+	fs.StringVar(&c.Log.Format, "log-format", c.Log.Format, "text or json")
+	fs.TextVar(&c.Log.Level, "log-level", c.Log.Level, "debug, info, warn or error")
+
+	fs.StringVar(&c.Database.URL, "database-url", c.Database.URL, "postgres connection string, a secret: set DATABASE_URL instead of the flag")
+	fs.IntVar(&c.Database.MaxConns, "database-max-conns", c.Database.MaxConns, "maximum open database connections")
+
+	fs.DurationVar(&c.Game.StartDelay, "start-delay", c.Game.StartDelay, "delay between starting a game and the first question")
+	fs.DurationVar(&c.Game.PromptDelay, "prompt-delay", c.Game.PromptDelay, "time a prompt is shown before its answers")
+	fs.DurationVar(&c.Game.AllAnsweredDelay, "all-answered-delay", c.Game.AllAnsweredDelay, "delay before revealing once every player answered")
+	fs.DurationVar(&c.Game.QuestionTime, "question-time", c.Game.QuestionTime, "time players have to answer a question")
+	fs.DurationVar(&c.Game.FinishDelay, "finish-delay", c.Game.FinishDelay, "time a finished room stays open")
+	fs.IntVar(&c.Game.MaxScore, "max-score", c.Game.MaxScore, "points for an instant correct answer")
+	fs.IntVar(&c.Game.MinScore, "min-score", c.Game.MinScore, "points for a correct answer at the end of the question time")
+
+	fs.IntVar(&c.Limits.MaxPlayersPerRoom, "max-players-per-room", c.Limits.MaxPlayersPerRoom, "players per room, 0 for unlimited")
+	fs.IntVar(&c.Limits.MaxPlayers, "max-players", c.Limits.MaxPlayers, "players across the server, 0 for unlimited")
+	fs.IntVar(&c.Limits.MaxSpectatorsPerRoom, "max-spectators-per-room", c.Limits.MaxSpectatorsPerRoom, "spectators per room, 0 for unlimited")
+	fs.Int64Var(&c.Limits.MaxMessageSize, "max-message-size", c.Limits.MaxMessageSize, "largest websocket message in bytes, 0 for unlimited")
+	fs.IntVar(&c.Limits.SendQueue, "send-queue", c.Limits.SendQueue, "outbound messages buffered per connection before a slow client is dropped")
+	fs.DurationVar(&c.Limits.WriteTimeout, "write-timeout", c.Limits.WriteTimeout, "time a client gets to receive a single message")
+	fs.DurationVar(&c.Limits.PingInterval, "ping-interval", c.Limits.PingInterval, "time between pings to hosts and players, 0 to disable heartbeats")
+	fs.DurationVar(&c.Limits.PongTimeout, "pong-timeout", c.Limits.PongTimeout, "time a client gets to answer a ping before it is disconnected")
+	fs.IntVar(&c.Limits.ReplayBuffer, "replay-buffer", c.Limits.ReplayBuffer, "events kept per room for clients to catch up after lagging or reconnecting")
+	c.Limits.PlayerEvents.bind(fs, "player")
+	c.Limits.HostEvents.bind(fs, "host")
+	c.Limits.Joins.bind(fs)
+
+	fs.StringVar(&c.Rooms.CodeFormat, "room-code-format", c.Rooms.CodeFormat, "numeric, alphanumeric or words")
+	fs.IntVar(&c.Rooms.CodeLength, "room-code-length", c.Rooms.CodeLength, "characters or words of room codes, 0 for the format's default")
+	fs.StringVar(&c.Rooms.BlockedWords, "blocked-words", c.Rooms.BlockedWords, "file of words not allowed in nicknames")
+	fs.IntVar(&c.Rooms.NicknameMinLength, "nickname-min-length", c.Rooms.NicknameMinLength, "fewest characters of a nickname")
+	fs.IntVar(&c.Rooms.NicknameMaxLength, "nickname-max-length", c.Rooms.NicknameMaxLength, "most characters of a nickname")
+
+	fs.StringVar(&c.OIDC.Issuer, "oidc-issuer", c.OIDC.Issuer, "OpenID Connect issuer, enables single sign-on")
+	fs.StringVar(&c.OIDC.ClientID, "oidc-client-id", c.OIDC.ClientID, "OpenID Connect client id")
+	fs.StringVar(&c.OIDC.ClientSecret, "oidc-client-secret", c.OIDC.ClientSecret, "OpenID Connect client secret, a secret: set KAHOOT_OIDC_CLIENT_SECRET instead of the flag")
+	fs.StringVar(&c.OIDC.RedirectURL, "oidc-redirect-url", c.OIDC.RedirectURL, "OpenID Connect callback url")
+	fs.StringVar(&c.OIDC.PostLoginURL, "oidc-post-login-url", c.OIDC.PostLoginURL, "page to return to after single sign-on")
+
+	return fs
+}
+
+func (r *RateLimit) bind(fs *flag.FlagSet, name string) {
+	fs.DurationVar(&r.Every, name+"-rate-every", r.Every, "one "+name+" event is allowed every interval")
+	fs.IntVar(&r.Burst, name+"-rate-burst", r.Burst, name+" events allowed at once")
+	fs.IntVar(&r.MaxViolations, name+"-rate-violations", r.MaxViolations, "dropped "+name+" events within the window before disconnecting")
+	fs.DurationVar(&r.Window, name+"-rate-window", r.Window, "window in which dropped "+name+" events are counted")
+}
+
+func (j *JoinGuard) bind(fs *flag.FlagSet) {
+	fs.IntVar(&j.FreeAttempts, "join-free-attempts", j.FreeAttempts, "joins of unknown rooms allowed per IP before backing off")
+	fs.DurationVar(&j.BaseDelay, "join-base-delay", j.BaseDelay, "first backoff after the free attempts, doubled on every further failure")
+	fs.DurationVar(&j.MaxDelay, "join-max-delay", j.MaxDelay, "longest backoff of an IP")
+	fs.DurationVar(&j.Forget, "join-forget", j.Forget, "time after which failed joins of an IP are forgotten")
+	fs.IntVar(&j.SuspiciousAfter, "join-suspicious-after", j.SuspiciousAfter, "failed joins from one IP before it is logged, 0 to never log")
+	fs.IntVar(&j.MaxPending, "join-max-pending", j.MaxPending, "joins from one IP looking up a room at once, 0 for unlimited")
+	fs.IntVar(&j.BreakerThreshold, "join-breaker-threshold", j.BreakerThreshold, "failed joins across the server within the window that reject every join, 0 to disable")
+	fs.DurationVar(&j.BreakerWindow, "join-breaker-window", j.BreakerWindow, "window in which failed joins across the server are counted")
+	fs.DurationVar(&j.BreakerCooldown, "join-breaker-cooldown", j.BreakerCooldown, "time every join is rejected once the breaker opens")
+}
+
+// Load reads the configuration from args, the environment and the config file.
+// The file is given by -config or KAHOOT_CONFIG, a positional argument is
+// accepted as the listen address for compatibility
+func Load(args []string, output io.Writer) (*Config, error) {
+	// flags are parsed once to find the config file and report usage errors early
+	pre := Default()
+	if err := pre.flagSet(output).Parse(args); err != nil {
+		return nil, err
+	}
+	if len(pre.File) == 0 {
+		pre.File = os.Getenv(ENV_PREFIX + "CONFIG")
+	}
+
+	c := Default()
+	fs := c.flagSet(output)
+
+	if len(pre.File) > 0 {
+		if err := loadFile(fs, pre.File); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok || err != nil {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid %s: %v", envName(f.Name), setErr)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	c.File = pre.File
+
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		c.Server.Addr = fs.Arg(0)
+	default:
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args()[1:], " "))
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func envName(flagName string) string {
+	if name, ok := envNames[flagName]; ok {
+		return name
+	}
+	return ENV_PREFIX + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// loadFile applies a file of "name = value" lines, empty lines and lines starting with # are ignored
+func loadFile(fs *flag.FlagSet, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	line := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		name, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return fmt.Errorf("%s:%d: expected name = value", path, line)
+		}
+		name = strings.TrimSpace(name)
+		if name == "config" {
+			return fmt.Errorf("%s:%d: config files cannot include other files", path, line)
+		}
+
+		if err := fs.Set(name, strings.TrimSpace(value)); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Validate reports the first setting that would keep the server from working
+func (c *Config) Validate() error {
+	if len(c.Server.Addr) == 0 {
+		return errors.New("addr is required")
+	}
+
+	for _, origin := range c.Server.Origins {
+		u, err := url.Parse(origin)
+		if err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+			return fmt.Errorf("origin %q must be a scheme and host such as http://localhost:5173", origin)
+		}
+	}
+
+	if c.Log.Format != LOG_FORMAT_TEXT && c.Log.Format != LOG_FORMAT_JSON {
+		return errors.New("log-format must be text or json")
+	}
+
+	if len(c.Database.URL) == 0 {
+		return errors.New("database-url is required, set DATABASE_URL")
+	}
+	if c.Database.MaxConns < 1 {
+		return errors.New("database-max-conns must be at least 1")
+	}
+
+	durations := map[string]time.Duration{
+		"shutdown-timeout":   c.Server.ShutdownTimeout,
+		"shutdown-drain":     c.Server.ShutdownDrain,
+		"readiness-grace":    c.Server.ReadinessGrace,
+		"start-delay":        c.Game.StartDelay,
+		"prompt-delay":       c.Game.PromptDelay,
+		"all-answered-delay": c.Game.AllAnsweredDelay,
+		"finish-delay":       c.Game.FinishDelay,
+	}
+	for name, d := range durations {
+		if d < 0 {
+			return fmt.Errorf("%s cannot be negative", name)
+		}
+	}
+
+	if c.Game.QuestionTime <= 0 {
+		return errors.New("question-time must be positive")
+	}
+	if c.Game.MinScore < 0 || c.Game.MinScore > c.Game.MaxScore {
+		return errors.New("min-score must be between 0 and max-score")
+	}
+
+	if c.Limits.MaxPlayersPerRoom < 0 || c.Limits.MaxPlayers < 0 || c.Limits.MaxSpectatorsPerRoom < 0 || c.Limits.MaxMessageSize < 0 {
+		return errors.New("limits cannot be negative")
+	}
+	if c.Limits.SendQueue < 1 {
+		return errors.New("send-queue must be at least 1")
+	}
+	if c.Limits.WriteTimeout <= 0 {
+		return errors.New("write-timeout must be positive")
+	}
+	if c.Limits.ReplayBuffer < 1 {
+		return errors.New("replay-buffer must be at least 1")
+	}
+	if c.Limits.PingInterval < 0 {
+		return errors.New("ping-interval cannot be negative")
+	}
+	if c.Limits.PingInterval > 0 && c.Limits.PongTimeout <= 0 {
+		return errors.New("pong-timeout must be positive when heartbeats are enabled")
+	}
+	for name, r := range map[string]RateLimit{"player": c.Limits.PlayerEvents, "host": c.Limits.HostEvents} {
+		if r.Every < 0 || r.Burst < 1 || r.MaxViolations < 0 || r.Window < 0 {
+			return fmt.Errorf("%s rate limit needs a burst of at least 1 and no negative values", name)
+		}
+	}
+
+	j := c.Limits.Joins
+	if j.FreeAttempts < 0 || j.SuspiciousAfter < 0 || j.MaxPending < 0 || j.BreakerThreshold < 0 {
+		return errors.New("join guard limits cannot be negative")
+	}
+	if j.BaseDelay <= 0 || j.MaxDelay < j.BaseDelay {
+		return errors.New("join-base-delay must be positive and no longer than join-max-delay")
+	}
+	if j.Forget <= 0 {
+		return errors.New("join-forget must be positive")
+	}
+	if j.BreakerThreshold > 0 && (j.BreakerWindow <= 0 || j.BreakerCooldown <= 0) {
+		return errors.New("join-breaker-window and join-breaker-cooldown must be positive when the breaker is enabled")
+	}
+
+	if c.Rooms.CodeLength < 0 {
+		return errors.New("room-code-length cannot be negative")
+	}
+	if c.Rooms.NicknameMinLength < 1 || c.Rooms.NicknameMaxLength < c.Rooms.NicknameMinLength {
+		return errors.New("nickname-min-length must be at least 1 and no more than nickname-max-length")
+	}
+
+	return nil
+}
+
+// OriginPatterns returns the hosts of the allowed origins, as websocket.AcceptOptions expects them
+func (s Server) OriginPatterns() []string {
+	patterns := make([]string, 0, len(s.Origins))
+	for _, origin := range s.Origins {
+		if u, err := url.Parse(origin); err == nil {
+			patterns = append(patterns, u.Host)
+		}
+	}
+	return patterns
+}
+
+// listValue is a comma separated flag value
+type listValue []string
+
+func (l *listValue) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *listValue) Set(value string) error {
+	*l = []string{}
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// NewLogger returns a logger writing to w in the configured format and level
+func (l Log) NewLogger(w io.Writer) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: l.Level}
+	if l.Format == LOG_FORMAT_JSON {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
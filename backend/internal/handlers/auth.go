@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/enzofalone/kahoot/internal/auth"
+	"github.com/enzofalone/kahoot/internal/repo"
+)
+
+const (
+	MIN_PASSWORD_LENGTH = 8
+	MAX_PASSWORD_LENGTH = 72 // bcrypt ignores anything longer
+)
+
+type AuthHandler struct {
+	logger   *slog.Logger
+	db       *repo.Database
+	sessions *auth.Sessions
+}
+
+func NewAuthHandler(logger *slog.Logger, db *repo.Database, sessions *auth.Sessions) *AuthHandler {
+	return &AuthHandler{
+		logger:   logger,
+		db:       db,
+		sessions: sessions,
+	}
+}
+
+type credentials struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type userResponse struct {
+	ID    int    `json:"id"`
+	Email string `json:"email"`
+}
+
+// Register creates a host account and logs it in
+func (a *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
+	var c credentials
+	if err := readJSON(w, r, &c); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(c.Email))
+	if !strings.Contains(email, "@") {
+		writeError(w, http.StatusBadRequest, "invalid email")
+		return
+	}
+
+	if len(c.Password) < MIN_PASSWORD_LENGTH || len(c.Password) > MAX_PASSWORD_LENGTH {
+		writeError(w, http.StatusBadRequest, "password must be between 8 and 72 characters")
+		return
+	}
+
+	hash, err := auth.HashPassword(c.Password)
+	if err != nil {
+		a.logger.Error("Register: failed to hash password", "err", err)
+		writeError(w, http.StatusInternalServerError, "failed to create account")
+		return
+	}
+
+	user, err := a.db.CreateUser(r.Context(), email, hash)
+	if errors.Is(err, repo.ErrConflict) {
+		writeError(w, http.StatusConflict, "email already registered")
+		return
+	}
+	if err != nil {
+		a.logger.Error("Register: failed to create user", "err", err)
+		writeError(w, http.StatusInternalServerError, "failed to create account")
+		return
+	}
+
+	if err := a.sessions.Create(r.Context(), w, user.ID); err != nil {
+		a.logger.Error("Register: failed to create session", "err", err)
+		writeError(w, http.StatusInternalServerError, "failed to log in")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, userResponse{ID: user.ID, Email: user.Email})
+}
+
+// Login checks credentials and starts a session
+func (a *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
+	var c credentials
+	if err := readJSON(w, r, &c); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	user, err := a.db.GetUserByEmail(r.Context(), strings.ToLower(strings.TrimSpace(c.Email)))
+	if err != nil && !errors.Is(err, repo.ErrNotFound) {
+		a.logger.Error("Login: failed to get user", "err", err)
+		writeError(w, http.StatusInternalServerError, "failed to log in")
+		return
+	}
+
+	// same answer, and the same time spent hashing, for unknown emails and wrong passwords
+	hash := ""
+	if user != nil {
+		hash = user.PasswordHash
+	}
+	if !auth.CheckPassword(hash, c.Password) {
+		writeError(w, http.StatusUnauthorized, "invalid email or password")
+		return
+	}
+
+	if err := a.sessions.Create(r.Context(), w, user.ID); err != nil {
+		a.logger.Error("Login: failed to create session", "err", err)
+		writeError(w, http.StatusInternalServerError, "failed to log in")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, userResponse{ID: user.ID, Email: user.Email})
+}
+
+func (a *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	if err := a.sessions.Destroy(w, r); err != nil {
+		a.logger.Error("Logout: failed to destroy session", "err", err)
+		writeError(w, http.StatusInternalServerError, "failed to log out")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Me returns the logged in user
+func (a *AuthHandler) Me(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFrom(r.Context())
+	writeJSON(w, http.StatusOK, userResponse{ID: user.ID, Email: user.Email})
+}
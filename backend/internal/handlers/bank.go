@@ -1,51 +1,205 @@
 package handler
 
-// import (
-// 	"context"
-// 	"fmt"
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 
-// 	"github.com/enzofalone/kahoot/internal/repo"
-// )
+	"github.com/enzofalone/kahoot/internal/auth"
+	"github.com/enzofalone/kahoot/internal/repo"
+)
 
-// const (
-// 	MAX_QUESTIONS = 30
-// )
+const (
+	MAX_QUESTIONS = 30
+)
 
-// type BankHandler struct {
-// 	logf func(f string, v ...interface{})
-// 	db   *repo.Database
-// }
+type BankHandler struct {
+	logger *slog.Logger
+	db     *repo.Database
+}
 
-// func NewBankHandler(logf func(f string, v ...interface{}), db *repo.Database) *BankHandler {
-// 	return &BankHandler{
-// 		logf: logf,
-// 		db:   db,
-// 	}
-// }
+func NewBankHandler(logger *slog.Logger, db *repo.Database) *BankHandler {
+	return &BankHandler{
+		logger: logger,
+		db:     db,
+	}
+}
 
-// func (b *BankHandler) CreateBank(ctx context.Context, bankName string) error {
-// 	if len(bankName) == 0 {
-// 		return fmt.Errorf("invalid bank name")
-// 	}
+type bankRequest struct {
+	Title     string          `json:"title"`
+	OrgID     *int            `json:"organizationId"` // only read when creating a bank
+	Questions []repo.Question `json:"questions"`
+}
 
-// 	if _, err := b.db.Exec(ctx, bankName); err != nil {
-// 		return err
-// 	}
-// 	return nil
-// }
+func (b bankRequest) validate() error {
+	if len(strings.TrimSpace(b.Title)) == 0 {
+		return errors.New("invalid bank name")
+	}
 
-// func (b *BankHandler) GetBankList(ctx context.Context, page int, limit int) {
-// 	banks, err := b.db.Query(ctx, `SELECT * FROM bank`)
-// }
+	if len(b.Questions) > MAX_QUESTIONS {
+		return errors.New("too many questions")
+	}
 
-// func (b *BankHandler) GetBank(ctx context.Context, bankName string) {
+	for _, q := range b.Questions {
+		if len(q.Prompt) == 0 {
+			return errors.New("question prompt is required")
+		}
+		if len(q.AnswerBank) < 2 {
+			return errors.New("questions need at least 2 answers")
+		}
+		if !slices.Contains(q.AnswerBank, q.CorrectAnswer) {
+			return errors.New("correct answer must be one of the answers")
+		}
+	}
 
-// }
+	return nil
+}
 
-// func (b *BankHandler) AddQuestion(ctx context.Context, bankName string, title string, questionBank []string) {
+func (b *BankHandler) CreateBank(w http.ResponseWriter, r *http.Request) {
+	var req bankRequest
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
 
-// }
+	if err := req.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
-// func (b *BankHandler) MoveQuestion(ctx context.Context, bankName string, title string, newPos int) {
+	user := auth.UserFrom(r.Context())
 
-// }
+	// banks can only be added to organizations the user may edit
+	if req.OrgID != nil {
+		role, err := b.db.MemberRole(r.Context(), *req.OrgID, user.ID)
+		if err != nil {
+			b.logger.Error("CreateBank failed", "err", err)
+			writeError(w, http.StatusInternalServerError, "failed to create bank")
+			return
+		}
+		if len(role) == 0 {
+			writeError(w, http.StatusNotFound, "organization not found")
+			return
+		}
+		if !repo.RoleAllows(role, repo.ROLE_EDITOR) {
+			writeError(w, http.StatusForbidden, "only editors can add banks to the organization")
+			return
+		}
+	}
+
+	bank, err := b.db.CreateBank(r.Context(), user.ID, req.OrgID, req.Title, req.Questions)
+	if err != nil {
+		b.logger.Error("CreateBank failed", "err", err)
+		writeError(w, http.StatusInternalServerError, "failed to create bank")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, bank)
+}
+
+func (b *BankHandler) GetBankList(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFrom(r.Context())
+	banks, err := b.db.ListBanks(r.Context(), user.ID)
+	if err != nil {
+		b.logger.Error("GetBankList failed", "err", err)
+		writeError(w, http.StatusInternalServerError, "failed to list banks")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, banks)
+}
+
+func (b *BankHandler) GetBank(w http.ResponseWriter, r *http.Request) {
+	bank, ok := b.accessibleBank(w, r, repo.ROLE_VIEWER)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, bank)
+}
+
+func (b *BankHandler) UpdateBank(w http.ResponseWriter, r *http.Request) {
+	bank, ok := b.accessibleBank(w, r, repo.ROLE_EDITOR)
+	if !ok {
+		return
+	}
+
+	var req bankRequest
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := req.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	updated, err := b.db.UpdateBank(r.Context(), bank.ID, req.Title, req.Questions)
+	if err != nil {
+		b.logger.Error("UpdateBank failed", "err", err)
+		writeError(w, http.StatusInternalServerError, "failed to update bank")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, updated)
+}
+
+func (b *BankHandler) DeleteBank(w http.ResponseWriter, r *http.Request) {
+	bank, ok := b.accessibleBank(w, r, repo.ROLE_OWNER)
+	if !ok {
+		return
+	}
+
+	if err := b.db.DeleteBank(r.Context(), bank.ID); err != nil {
+		b.logger.Error("DeleteBank failed", "err", err)
+		writeError(w, http.StatusInternalServerError, "failed to delete bank")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// accessibleBank loads the bank of the {id} path value, answering with an error
+// unless the logged in user has at least the required role on it
+func (b *BankHandler) accessibleBank(w http.ResponseWriter, r *http.Request, required string) (*repo.Bank, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid bank id")
+		return nil, false
+	}
+
+	bank, err := b.db.GetBank(r.Context(), id)
+	if errors.Is(err, repo.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "bank not found")
+		return nil, false
+	}
+	if err != nil {
+		b.logger.Error("accessibleBank failed", "err", err)
+		writeError(w, http.StatusInternalServerError, "failed to get bank")
+		return nil, false
+	}
+
+	role, err := b.db.BankRole(r.Context(), bank, auth.UserFrom(r.Context()).ID)
+	if err != nil {
+		b.logger.Error("accessibleBank failed", "err", err)
+		writeError(w, http.StatusInternalServerError, "failed to get bank")
+		return nil, false
+	}
+
+	// do not reveal banks of other users exist
+	if len(role) == 0 {
+		writeError(w, http.StatusNotFound, "bank not found")
+		return nil, false
+	}
+	if !repo.RoleAllows(role, required) {
+		writeError(w, http.StatusForbidden, "your role does not allow this")
+		return nil, false
+	}
+
+	bank.Role = role
+	return bank, true
+}
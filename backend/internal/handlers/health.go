@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/enzofalone/kahoot/internal/repo"
+)
+
+const READY_CHECK_TIMEOUT = 2 * time.Second
+
+// HealthHandler answers liveness and readiness probes
+type HealthHandler struct {
+	logger   *slog.Logger
+	db       *repo.Database
+	full     func() bool // reports whether the server takes no more players
+	draining atomic.Bool
+}
+
+func NewHealthHandler(logger *slog.Logger, db *repo.Database, full func() bool) *HealthHandler {
+	return &HealthHandler{
+		logger: logger,
+		db:     db,
+		full:   full,
+	}
+}
+
+type readiness struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// Drain makes readiness fail from now on, so load balancers stop sending traffic before shutting down
+func (h *HealthHandler) Drain() {
+	h.draining.Store(true)
+}
+
+// Healthz reports the process is alive
+func (h *HealthHandler) Healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Readyz reports whether the server can take new games
+func (h *HealthHandler) Readyz(w http.ResponseWriter, r *http.Request) {
+	res := readiness{
+		Status: "ok",
+		Checks: map[string]string{
+			"database": "ok",
+			"shutdown": "ok",
+			"capacity": "ok",
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), READY_CHECK_TIMEOUT)
+	defer cancel()
+
+	if err := h.db.Ping(ctx); err != nil {
+		h.logger.Warn("Readiness check failed to reach the database", "err", err)
+		res.Checks["database"] = "unreachable"
+		res.Status = "unavailable"
+	}
+
+	if h.draining.Load() {
+		res.Checks["shutdown"] = "shutting down"
+		res.Status = "unavailable"
+	}
+
+	if h.full != nil && h.full() {
+		res.Checks["capacity"] = "full"
+		res.Status = "unavailable"
+	}
+
+	status := http.StatusOK
+	if res.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, res)
+}
@@ -0,0 +1,202 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/enzofalone/kahoot/internal/auth"
+	"github.com/enzofalone/kahoot/internal/repo"
+	"golang.org/x/oauth2"
+)
+
+const OIDC_FLOW_COOKIE = "kahoot_oidc_flow"
+const OIDC_FLOW_DURATION = 10 * time.Minute
+
+// OIDCHandler logs hosts in through an OpenID Connect provider as an
+// alternative to passwords
+type OIDCHandler struct {
+	logger   *slog.Logger
+	db       identityStore
+	sessions sessionStarter
+	oidc     *auth.OIDC
+	secure   bool
+}
+
+// identityStore is the part of the database single sign-on needs
+type identityStore interface {
+	GetUserByIdentity(ctx context.Context, issuer string, subject string) (*repo.User, error)
+	GetUserByEmail(ctx context.Context, email string) (*repo.User, error)
+	CreateUser(ctx context.Context, email string, passwordHash string) (*repo.User, error)
+	LinkIdentity(ctx context.Context, issuer string, subject string, userID int) error
+}
+
+type sessionStarter interface {
+	Create(ctx context.Context, w http.ResponseWriter, userID int) error
+}
+
+func NewOIDCHandler(logger *slog.Logger, db *repo.Database, sessions *auth.Sessions, oidc *auth.OIDC, secure bool) *OIDCHandler {
+	return &OIDCHandler{
+		logger:   logger,
+		db:       db,
+		sessions: sessions,
+		oidc:     oidc,
+		secure:   secure,
+	}
+}
+
+// oidcFlow is kept in a short lived cookie between the redirect to the provider and the callback
+type oidcFlow struct {
+	State        string `json:"state"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"codeVerifier"`
+}
+
+// Login redirects the host to the identity provider
+func (o *OIDCHandler) Login(w http.ResponseWriter, r *http.Request) {
+	flow := oidcFlow{
+		State:        randomValue(),
+		Nonce:        randomValue(),
+		CodeVerifier: oauth2.GenerateVerifier(),
+	}
+
+	flowJson, err := json.Marshal(flow)
+	if err != nil {
+		o.logger.Error("OIDC Login: failed to marshal flow", "err", err)
+		writeError(w, http.StatusInternalServerError, "failed to start login")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     OIDC_FLOW_COOKIE,
+		Value:    base64.RawURLEncoding.EncodeToString(flowJson),
+		Path:     "/auth/oidc",
+		MaxAge:   int(OIDC_FLOW_DURATION.Seconds()),
+		HttpOnly: true,
+		Secure:   o.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, o.oidc.AuthCodeURL(flow.State, flow.Nonce, flow.CodeVerifier), http.StatusFound)
+}
+
+// Callback completes the login once the provider redirects back
+func (o *OIDCHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	flow, err := readFlow(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "login expired, please try again")
+		return
+	}
+
+	// the flow cookie is single use
+	http.SetCookie(w, &http.Cookie{
+		Name:     OIDC_FLOW_COOKIE,
+		Value:    "",
+		Path:     "/auth/oidc",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   o.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	query := r.URL.Query()
+	if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(flow.State)) != 1 {
+		writeError(w, http.StatusBadRequest, "invalid login state")
+		return
+	}
+
+	if e := query.Get("error"); len(e) > 0 {
+		writeError(w, http.StatusUnauthorized, "login was denied: "+e)
+		return
+	}
+
+	identity, err := o.oidc.Exchange(r.Context(), query.Get("code"), flow.Nonce, flow.CodeVerifier)
+	if err != nil {
+		o.logger.Error("OIDC Callback failed", "err", err)
+		writeError(w, http.StatusUnauthorized, "failed to log in")
+		return
+	}
+
+	user, err := o.userFor(r, identity)
+	if err != nil {
+		o.logger.Error("OIDC Callback: failed to map identity to a host", "subject", identity.Subject, "err", err)
+		writeError(w, http.StatusForbidden, "failed to log in")
+		return
+	}
+
+	if err := o.sessions.Create(r.Context(), w, user.ID); err != nil {
+		o.logger.Error("OIDC Callback: failed to create session", "err", err)
+		writeError(w, http.StatusInternalServerError, "failed to log in")
+		return
+	}
+
+	http.Redirect(w, r, o.oidc.PostLoginURL(), http.StatusFound)
+}
+
+// userFor finds the host account of an identity, linking it to the account
+// with the same verified email or creating a new account on first login
+func (o *OIDCHandler) userFor(r *http.Request, identity *auth.Identity) (*repo.User, error) {
+	ctx := r.Context()
+
+	user, err := o.db.GetUserByIdentity(ctx, identity.Issuer, identity.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, repo.ErrNotFound) {
+		return nil, err
+	}
+
+	// only trust emails the provider has verified, otherwise anyone could take over an account
+	email := strings.ToLower(strings.TrimSpace(identity.Email))
+	if len(email) == 0 || !identity.EmailVerified {
+		return nil, errors.New("identity has no verified email")
+	}
+
+	user, err = o.db.GetUserByEmail(ctx, email)
+	if errors.Is(err, repo.ErrNotFound) {
+		// accounts created through the provider have no password and cannot log in with one
+		user, err = o.db.CreateUser(ctx, email, "")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := o.db.LinkIdentity(ctx, identity.Issuer, identity.Subject, user.ID); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func readFlow(r *http.Request) (*oidcFlow, error) {
+	cookie, err := r.Cookie(OIDC_FLOW_COOKIE)
+	if err != nil {
+		return nil, err
+	}
+
+	flowJson, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	var flow oidcFlow
+	if err := json.Unmarshal(flowJson, &flow); err != nil {
+		return nil, err
+	}
+	return &flow, nil
+}
+
+func randomValue() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
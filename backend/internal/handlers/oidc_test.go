@@ -0,0 +1,373 @@
+package handler
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/enzofalone/kahoot/internal/auth"
+	"github.com/enzofalone/kahoot/internal/repo"
+)
+
+const TEST_CLIENT_ID = "kahoot-test"
+
+// testProvider is an in-process identity provider serving discovery, keys
+// and the token endpoint. Codes are handed out by authorize in place of the
+// login page of a real provider
+type testProvider struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+type authorization struct {
+	challenge string
+	nonce     string
+	claims    map[string]any
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &testProvider{t: t, key: key, codes: make(map[string]authorization)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("GET /keys", p.keys)
+	mux.HandleFunc("POST /token", p.token)
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+
+	return p
+}
+
+func (p *testProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]any{
+		"issuer":                                p.server.URL,
+		"authorization_endpoint":                p.server.URL + "/authorize",
+		"token_endpoint":                        p.server.URL + "/token",
+		"jwks_uri":                              p.server.URL + "/keys",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (p *testProvider) keys(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": "test",
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
+
+// token exchanges a code for an ID token, checking the PKCE verifier against
+// the challenge the code was issued for
+func (p *testProvider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p.mu.Lock()
+	a, exists := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !exists || base64.RawURLEncoding.EncodeToString(sum[:]) != a.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, `{"error":"invalid_grant"}`)
+		return
+	}
+
+	claims := map[string]any{
+		"iss":   p.server.URL,
+		"aud":   TEST_CLIENT_ID,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": a.nonce,
+	}
+	for name, value := range a.claims {
+		claims[name] = value
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     p.sign(claims),
+	})
+}
+
+func (p *testProvider) sign(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": "test"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	sum := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, sum[:])
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// authorize stands in for the user logging in at the provider, returning the
+// code the provider redirects back with
+func (p *testProvider) authorize(authURL string, claims map[string]any) (state string, code string) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	query := u.Query()
+
+	code = "code-" + query.Get("state")
+	p.mu.Lock()
+	p.codes[code] = authorization{
+		challenge: query.Get("code_challenge"),
+		nonce:     query.Get("nonce"),
+		claims:    claims,
+	}
+	p.mu.Unlock()
+
+	return query.Get("state"), code
+}
+
+// memoryStore keeps users and identities for the handler
+type memoryStore struct {
+	users      map[string]*repo.User // by email
+	identities map[string]int        // user ID by issuer and subject
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		users:      make(map[string]*repo.User),
+		identities: make(map[string]int),
+	}
+}
+
+func (s *memoryStore) GetUserByIdentity(ctx context.Context, issuer string, subject string) (*repo.User, error) {
+	id, exists := s.identities[issuer+" "+subject]
+	if !exists {
+		return nil, repo.ErrNotFound
+	}
+	for _, u := range s.users {
+		if u.ID == id {
+			return u, nil
+		}
+	}
+	return nil, repo.ErrNotFound
+}
+
+func (s *memoryStore) GetUserByEmail(ctx context.Context, email string) (*repo.User, error) {
+	u, exists := s.users[email]
+	if !exists {
+		return nil, repo.ErrNotFound
+	}
+	return u, nil
+}
+
+func (s *memoryStore) CreateUser(ctx context.Context, email string, passwordHash string) (*repo.User, error) {
+	u := &repo.User{ID: len(s.users) + 1, Email: email, PasswordHash: passwordHash}
+	s.users[email] = u
+	return u, nil
+}
+
+func (s *memoryStore) LinkIdentity(ctx context.Context, issuer string, subject string, userID int) error {
+	s.identities[issuer+" "+subject] = userID
+	return nil
+}
+
+// recordedSessions remembers which users were logged in
+type recordedSessions struct {
+	users []int
+}
+
+func (s *recordedSessions) Create(ctx context.Context, w http.ResponseWriter, userID int) error {
+	s.users = append(s.users, userID)
+	return nil
+}
+
+type oidcTest struct {
+	provider *testProvider
+	store    *memoryStore
+	sessions *recordedSessions
+	handler  *OIDCHandler
+}
+
+func newOIDCTest(t *testing.T) *oidcTest {
+	provider := newTestProvider(t)
+
+	oidc, err := auth.NewOIDC(context.Background(), auth.OIDCConfig{
+		Issuer:       provider.server.URL,
+		ClientID:     TEST_CLIENT_ID,
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/auth/oidc/callback",
+		PostLoginURL: "http://localhost/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test := &oidcTest{
+		provider: provider,
+		store:    newMemoryStore(),
+		sessions: &recordedSessions{},
+	}
+	test.handler = &OIDCHandler{
+		logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+		db:       test.store,
+		sessions: test.sessions,
+		oidc:     oidc,
+	}
+	return test
+}
+
+// login runs the whole flow for a user with the given claims, letting tamper
+// change the callback request before it is handled
+func (o *oidcTest) login(claims map[string]any, tamper func(r *http.Request)) *httptest.ResponseRecorder {
+	start := httptest.NewRecorder()
+	o.handler.Login(start, httptest.NewRequest(http.MethodGet, "/auth/oidc/login", nil))
+	if start.Code != http.StatusFound {
+		o.provider.t.Fatalf("login: got status %d, want %d", start.Code, http.StatusFound)
+	}
+
+	state, code := o.provider.authorize(start.Header().Get("Location"), claims)
+	callback := httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?"+url.Values{"state": {state}, "code": {code}}.Encode(), nil)
+	for _, cookie := range start.Result().Cookies() {
+		callback.AddCookie(cookie)
+	}
+	if tamper != nil {
+		tamper(callback)
+	}
+
+	rec := httptest.NewRecorder()
+	o.handler.Callback(rec, callback)
+	return rec
+}
+
+func verifiedUser(subject string, email string) map[string]any {
+	return map[string]any{"sub": subject, "email": email, "email_verified": true}
+}
+
+func TestOIDCCreatesHostOnFirstLogin(t *testing.T) {
+	test := newOIDCTest(t)
+
+	rec := test.login(verifiedUser("alice", "Alice@School.example"), nil)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusFound, rec.Body)
+	}
+
+	user, exists := test.store.users["alice@school.example"]
+	if !exists {
+		t.Fatal("no host account was created")
+	}
+	if len(test.sessions.users) != 1 || test.sessions.users[0] != user.ID {
+		t.Fatalf("got sessions for %v, want [%d]", test.sessions.users, user.ID)
+	}
+
+	// the second login finds the account through the linked identity
+	test.login(verifiedUser("alice", "changed@school.example"), nil)
+	if len(test.store.users) != 1 || test.sessions.users[1] != user.ID {
+		t.Fatalf("second login used another account: %v", test.sessions.users)
+	}
+}
+
+func TestOIDCRejectsStateMismatch(t *testing.T) {
+	test := newOIDCTest(t)
+
+	rec := test.login(verifiedUser("alice", "alice@school.example"), func(r *http.Request) {
+		query := r.URL.Query()
+		query.Set("state", "forged")
+		r.URL.RawQuery = query.Encode()
+	})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if len(test.sessions.users) > 0 {
+		t.Fatal("a session was created")
+	}
+}
+
+func TestOIDCRejectsPKCEMismatch(t *testing.T) {
+	test := newOIDCTest(t)
+
+	rec := test.login(verifiedUser("alice", "alice@school.example"), func(r *http.Request) {
+		flow, err := readFlow(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		flow.CodeVerifier = strings.Repeat("x", 43)
+		flowJson, _ := json.Marshal(flow)
+
+		r.Header.Del("Cookie")
+		r.AddCookie(&http.Cookie{Name: OIDC_FLOW_COOKIE, Value: base64.RawURLEncoding.EncodeToString(flowJson)})
+	})
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if len(test.sessions.users) > 0 {
+		t.Fatal("a session was created")
+	}
+}
+
+func TestOIDCRejectsUnverifiedEmail(t *testing.T) {
+	test := newOIDCTest(t)
+	test.store.CreateUser(context.Background(), "alice@school.example", "hash")
+
+	rec := test.login(map[string]any{"sub": "mallory", "email": "alice@school.example", "email_verified": false}, nil)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if len(test.store.identities) > 0 || len(test.sessions.users) > 0 {
+		t.Fatal("an unverified email was linked to an account")
+	}
+}
+
+func TestOIDCLinksExistingAccount(t *testing.T) {
+	test := newOIDCTest(t)
+	existing, _ := test.store.CreateUser(context.Background(), "alice@school.example", "hash")
+
+	rec := test.login(verifiedUser("alice", "alice@school.example"), nil)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusFound, rec.Body)
+	}
+
+	if len(test.store.users) != 1 {
+		t.Fatalf("got %d accounts, want the existing one only", len(test.store.users))
+	}
+	if id := test.store.identities[test.provider.server.URL+" alice"]; id != existing.ID {
+		t.Fatalf("identity linked to account %d, want %d", id, existing.ID)
+	}
+	if test.sessions.users[0] != existing.ID {
+		t.Fatalf("session created for account %d, want %d", test.sessions.users[0], existing.ID)
+	}
+}
@@ -0,0 +1,213 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/enzofalone/kahoot/internal/auth"
+	"github.com/enzofalone/kahoot/internal/repo"
+)
+
+type OrgHandler struct {
+	logger *slog.Logger
+	db     *repo.Database
+}
+
+func NewOrgHandler(logger *slog.Logger, db *repo.Database) *OrgHandler {
+	return &OrgHandler{
+		logger: logger,
+		db:     db,
+	}
+}
+
+type orgRequest struct {
+	Name string `json:"name"`
+}
+
+type memberRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// CreateOrganization creates an organization owned by the logged in user
+func (o *OrgHandler) CreateOrganization(w http.ResponseWriter, r *http.Request) {
+	var req orgRequest
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if len(name) == 0 {
+		writeError(w, http.StatusBadRequest, "organization name is required")
+		return
+	}
+
+	user := auth.UserFrom(r.Context())
+	org, err := o.db.CreateOrganization(r.Context(), user.ID, name)
+	if err != nil {
+		o.logger.Error("CreateOrganization failed", "err", err)
+		writeError(w, http.StatusInternalServerError, "failed to create organization")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, org)
+}
+
+func (o *OrgHandler) GetOrganizationList(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFrom(r.Context())
+	orgs, err := o.db.ListOrganizations(r.Context(), user.ID)
+	if err != nil {
+		o.logger.Error("GetOrganizationList failed", "err", err)
+		writeError(w, http.StatusInternalServerError, "failed to list organizations")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, orgs)
+}
+
+func (o *OrgHandler) GetMemberList(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := o.memberOf(w, r, repo.ROLE_VIEWER)
+	if !ok {
+		return
+	}
+
+	members, err := o.db.ListMembers(r.Context(), orgID)
+	if err != nil {
+		o.logger.Error("GetMemberList failed", "err", err)
+		writeError(w, http.StatusInternalServerError, "failed to list members")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, members)
+}
+
+// SetMember adds a registered host to the organization or changes their role
+func (o *OrgHandler) SetMember(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := o.memberOf(w, r, repo.ROLE_OWNER)
+	if !ok {
+		return
+	}
+
+	var req memberRequest
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if !repo.ValidRole(req.Role) {
+		writeError(w, http.StatusBadRequest, "role must be one of owner, editor, viewer")
+		return
+	}
+
+	member, err := o.db.GetUserByEmail(r.Context(), strings.ToLower(strings.TrimSpace(req.Email)))
+	if errors.Is(err, repo.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if err != nil {
+		o.logger.Error("SetMember failed", "err", err)
+		writeError(w, http.StatusInternalServerError, "failed to add member")
+		return
+	}
+
+	if req.Role != repo.ROLE_OWNER && !o.keepsOwner(w, r, orgID, member.ID) {
+		return
+	}
+
+	if err := o.db.SetMember(r.Context(), orgID, member.ID, req.Role); err != nil {
+		o.logger.Error("SetMember failed", "err", err)
+		writeError(w, http.StatusInternalServerError, "failed to add member")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, repo.Member{UserID: member.ID, Email: member.Email, Role: req.Role})
+}
+
+func (o *OrgHandler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := o.memberOf(w, r, repo.ROLE_OWNER)
+	if !ok {
+		return
+	}
+
+	userID, err := strconv.Atoi(r.PathValue("userId"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+
+	if !o.keepsOwner(w, r, orgID, userID) {
+		return
+	}
+
+	err = o.db.RemoveMember(r.Context(), orgID, userID)
+	if errors.Is(err, repo.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "member not found")
+		return
+	}
+	if err != nil {
+		o.logger.Error("RemoveMember failed", "err", err)
+		writeError(w, http.StatusInternalServerError, "failed to remove member")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// memberOf parses the {id} path value, answering with an error unless the
+// logged in user has at least the required role in that organization
+func (o *OrgHandler) memberOf(w http.ResponseWriter, r *http.Request, required string) (int, bool) {
+	orgID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid organization id")
+		return 0, false
+	}
+
+	role, err := o.db.MemberRole(r.Context(), orgID, auth.UserFrom(r.Context()).ID)
+	if err != nil {
+		o.logger.Error("memberOf failed", "err", err)
+		writeError(w, http.StatusInternalServerError, "failed to get organization")
+		return 0, false
+	}
+
+	// do not reveal organizations of other users exist
+	if len(role) == 0 {
+		writeError(w, http.StatusNotFound, "organization not found")
+		return 0, false
+	}
+	if !repo.RoleAllows(role, required) {
+		writeError(w, http.StatusForbidden, "your role does not allow this")
+		return 0, false
+	}
+
+	return orgID, true
+}
+
+// keepsOwner answers with an error if demoting or removing userID would leave
+// the organization without an owner
+func (o *OrgHandler) keepsOwner(w http.ResponseWriter, r *http.Request, orgID int, userID int) bool {
+	role, err := o.db.MemberRole(r.Context(), orgID, userID)
+	if err == nil && role != repo.ROLE_OWNER {
+		return true
+	}
+
+	var owners int
+	if err == nil {
+		owners, err = o.db.CountOwners(r.Context(), orgID)
+	}
+	if err != nil {
+		o.logger.Error("keepsOwner failed", "err", err)
+		writeError(w, http.StatusInternalServerError, "failed to change members")
+		return false
+	}
+
+	if owners <= 1 {
+		writeError(w, http.StatusConflict, "organizations need at least one owner")
+		return false
+	}
+
+	return true
+}
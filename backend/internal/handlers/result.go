@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/enzofalone/kahoot/internal/auth"
+	"github.com/enzofalone/kahoot/internal/repo"
+)
+
+type ResultHandler struct {
+	logger *slog.Logger
+	db     *repo.Database
+}
+
+func NewResultHandler(logger *slog.Logger, db *repo.Database) *ResultHandler {
+	return &ResultHandler{
+		logger: logger,
+		db:     db,
+	}
+}
+
+type gameResults struct {
+	repo.Game
+	Results []repo.Result `json:"results"`
+}
+
+func (g *ResultHandler) GetGameList(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFrom(r.Context())
+	games, err := g.db.ListGames(r.Context(), user.ID)
+	if err != nil {
+		g.logger.Error("GetGameList failed", "err", err)
+		writeError(w, http.StatusInternalServerError, "failed to list games")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, games)
+}
+
+func (g *ResultHandler) GetResults(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid game id")
+		return
+	}
+
+	game, err := g.db.GetGame(r.Context(), id)
+	if err == nil {
+		err = g.canView(r, game)
+	}
+	if errors.Is(err, repo.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "game not found")
+		return
+	}
+	if err != nil {
+		g.logger.Error("GetResults failed", "err", err)
+		writeError(w, http.StatusInternalServerError, "failed to get game")
+		return
+	}
+
+	results, err := g.db.GetResults(r.Context(), id)
+	if err != nil {
+		g.logger.Error("GetResults failed", "err", err)
+		writeError(w, http.StatusInternalServerError, "failed to get results")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, gameResults{Game: *game, Results: results})
+}
+
+// canView returns ErrNotFound unless the logged in user hosted the game or may
+// edit the banks of the organization it was played for
+func (g *ResultHandler) canView(r *http.Request, game *repo.Game) error {
+	user := auth.UserFrom(r.Context())
+	if game.OwnerID == user.ID {
+		return nil
+	}
+
+	if game.OrgID == nil {
+		return repo.ErrNotFound
+	}
+
+	role, err := g.db.MemberRole(r.Context(), *game.OrgID, user.ID)
+	if err != nil {
+		return err
+	}
+	if !repo.RoleAllows(role, repo.ROLE_EDITOR) {
+		return repo.ErrNotFound
+	}
+
+	return nil
+}
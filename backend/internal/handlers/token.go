@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/enzofalone/kahoot/internal/auth"
+	"github.com/enzofalone/kahoot/internal/repo"
+)
+
+type TokenHandler struct {
+	logger *slog.Logger
+	db     *repo.Database
+}
+
+func NewTokenHandler(logger *slog.Logger, db *repo.Database) *TokenHandler {
+	return &TokenHandler{
+		logger: logger,
+		db:     db,
+	}
+}
+
+type tokenRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+type tokenResponse struct {
+	repo.APIToken
+	Token string `json:"token"` // only returned when the token is created
+}
+
+// CreateToken issues a personal access token, the plain token is only shown once
+func (t *TokenHandler) CreateToken(w http.ResponseWriter, r *http.Request) {
+	var req tokenRequest
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if len(name) == 0 {
+		writeError(w, http.StatusBadRequest, "token name is required")
+		return
+	}
+
+	if !auth.ValidScopes(req.Scopes) {
+		writeError(w, http.StatusBadRequest, "scopes must be some of "+strings.Join(auth.Scopes, ", "))
+		return
+	}
+
+	token, hash, err := auth.GenerateAPIToken()
+	if err != nil {
+		t.logger.Error("CreateToken: failed to generate token", "err", err)
+		writeError(w, http.StatusInternalServerError, "failed to create token")
+		return
+	}
+
+	user := auth.UserFrom(r.Context())
+	stored, err := t.db.CreateAPIToken(r.Context(), user.ID, name, hash, req.Scopes)
+	if err != nil {
+		t.logger.Error("CreateToken failed", "err", err)
+		writeError(w, http.StatusInternalServerError, "failed to create token")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, tokenResponse{APIToken: *stored, Token: token})
+}
+
+func (t *TokenHandler) GetTokenList(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFrom(r.Context())
+	tokens, err := t.db.ListAPITokens(r.Context(), user.ID)
+	if err != nil {
+		t.logger.Error("GetTokenList failed", "err", err)
+		writeError(w, http.StatusInternalServerError, "failed to list tokens")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+func (t *TokenHandler) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid token id")
+		return
+	}
+
+	user := auth.UserFrom(r.Context())
+	err = t.db.RevokeAPIToken(r.Context(), user.ID, id)
+	if errors.Is(err, repo.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "token not found")
+		return
+	}
+	if err != nil {
+		t.logger.Error("RevokeToken failed", "err", err)
+		writeError(w, http.StatusInternalServerError, "failed to revoke token")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
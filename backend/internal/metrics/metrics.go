@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Connection roles
+const (
+	ROLE_HOST       = "host"
+	ROLE_PLAYER     = "player"
+	ROLE_SPECTATOR  = "spectator"
+	ROLE_CONTROLLER = "controller"
+)
+
+// Message directions
+const (
+	DIRECTION_IN  = "in"
+	DIRECTION_OUT = "out"
+)
+
+var (
+	RoomsActive = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "kahoot_rooms_active",
+		Help: "Rooms currently open.",
+	})
+
+	Connections = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kahoot_connections",
+		Help: "Websocket connections currently open by role.",
+	}, []string{"role"})
+
+	Messages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kahoot_messages_total",
+		Help: "Websocket messages by direction and event type.",
+	}, []string{"direction", "event"})
+
+	BroadcastDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "kahoot_broadcast_duration_seconds",
+		Help:    "Time taken to queue an event for everyone in a room.",
+		Buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	})
+
+	BroadcastFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kahoot_broadcast_failures_total",
+		Help: "Events that could not be written to a connection.",
+	})
+
+	SlowConsumers = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kahoot_slow_consumers_total",
+		Help: "Connections dropped because their send queue filled up.",
+	})
+
+	RateLimitedMessages = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kahoot_rate_limited_messages_total",
+		Help: "Client events dropped for exceeding the rate limit of their connection.",
+	})
+
+	RateLimitedDisconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kahoot_rate_limited_disconnects_total",
+		Help: "Connections closed for exceeding their rate limit too often.",
+	})
+
+	HeartbeatTimeouts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kahoot_heartbeat_timeouts_total",
+		Help: "Connections dropped for not answering a ping in time by role.",
+	}, []string{"role"})
+
+	AnswerLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "kahoot_answer_latency_seconds",
+		Help:    "Time between a question being shown and a player answering it.",
+		Buckets: []float64{1, 2, 3, 5, 7.5, 10, 15, 20, 25, 30},
+	})
+
+	GamesStarted = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kahoot_games_started_total",
+		Help: "Games started by hosts.",
+	})
+
+	GamesFinished = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kahoot_games_finished_total",
+		Help: "Games that reached the final results.",
+	})
+
+	QueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kahoot_db_query_duration_seconds",
+		Help:    "Duration of database queries by operation and table.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation", "table"})
+)
@@ -0,0 +1,157 @@
+package repo
+
+import (
+	"context"
+)
+
+// Bank is a collection of questions created by a host, optionally shared with an organization
+type Bank struct {
+	ID        int        `json:"id"`
+	OwnerID   *int       `json:"ownerId"` // nil for banks created before hosts had accounts
+	OrgID     *int       `json:"organizationId"`
+	Title     string     `json:"title"`
+	Role      string     `json:"role,omitempty"` // role of the user the bank was listed for
+	Questions []Question `json:"questions,omitempty"`
+}
+
+type Question struct {
+	ID            int      `json:"id"`
+	BankID        int      `json:"bankId"`
+	Prompt        string   `json:"prompt"`
+	AnswerBank    []string `json:"answerBank"`
+	CorrectAnswer string   `json:"correctAnswer"`
+}
+
+// CreateBank stores a bank and its questions, orgID is nil for personal banks
+func (db *Database) CreateBank(ctx context.Context, ownerID int, orgID *int, title string, questions []Question) (*Bank, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	b := &Bank{
+		OwnerID: &ownerID,
+		OrgID:   orgID,
+		Title:   title,
+	}
+
+	if err := tx.QueryRow(ctx,
+		`INSERT INTO bank (owner_id, org_id, title) VALUES ($1, $2, $3) RETURNING id`,
+		ownerID, orgID, title,
+	).Scan(&b.ID); err != nil {
+		return nil, translateError(err)
+	}
+
+	for _, q := range questions {
+		q.BankID = b.ID
+		if err := tx.QueryRow(ctx,
+			`INSERT INTO question (bank_id, prompt, answer_bank, correct_answer) VALUES ($1, $2, $3, $4) RETURNING id`,
+			b.ID, q.Prompt, q.AnswerBank, q.CorrectAnswer,
+		).Scan(&q.ID); err != nil {
+			return nil, translateError(err)
+		}
+		b.Questions = append(b.Questions, q)
+	}
+
+	return b, tx.Commit(ctx)
+}
+
+// ListBanks returns the personal banks of a user and the banks of their
+// organizations, without their questions
+func (db *Database) ListBanks(ctx context.Context, userID int) ([]Bank, error) {
+	rows, err := db.Query(ctx,
+		`SELECT b.id, b.owner_id, b.org_id, b.title, COALESCE(m.role, $2)
+		FROM bank b LEFT JOIN org_member m ON m.org_id = b.org_id AND m.user_id = $1
+		WHERE (b.org_id IS NULL AND b.owner_id = $1) OR m.user_id IS NOT NULL
+		ORDER BY b.id`, userID, ROLE_OWNER,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	banks := []Bank{}
+	for rows.Next() {
+		var b Bank
+		if err := rows.Scan(&b.ID, &b.OwnerID, &b.OrgID, &b.Title, &b.Role); err != nil {
+			return nil, err
+		}
+		banks = append(banks, b)
+	}
+
+	return banks, rows.Err()
+}
+
+// GetBank returns a bank with its questions
+func (db *Database) GetBank(ctx context.Context, id int) (*Bank, error) {
+	b := &Bank{}
+	if err := db.QueryRow(ctx,
+		`SELECT id, owner_id, org_id, title FROM bank WHERE id = $1`, id,
+	).Scan(&b.ID, &b.OwnerID, &b.OrgID, &b.Title); err != nil {
+		return nil, translateError(err)
+	}
+
+	rows, err := db.Query(ctx,
+		`SELECT id, bank_id, prompt, answer_bank, correct_answer FROM question WHERE bank_id = $1 ORDER BY id`, id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	b.Questions = []Question{}
+	for rows.Next() {
+		var q Question
+		if err := rows.Scan(&q.ID, &q.BankID, &q.Prompt, &q.AnswerBank, &q.CorrectAnswer); err != nil {
+			return nil, err
+		}
+		b.Questions = append(b.Questions, q)
+	}
+
+	return b, rows.Err()
+}
+
+// UpdateBank replaces the title and questions of a bank
+func (db *Database) UpdateBank(ctx context.Context, id int, title string, questions []Question) (*Bank, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	b := &Bank{ID: id, Title: title}
+	if err := tx.QueryRow(ctx,
+		`UPDATE bank SET title = $2 WHERE id = $1 RETURNING owner_id, org_id`, id, title,
+	).Scan(&b.OwnerID, &b.OrgID); err != nil {
+		return nil, translateError(err)
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM question WHERE bank_id = $1`, id); err != nil {
+		return nil, err
+	}
+
+	for _, q := range questions {
+		q.BankID = id
+		if err := tx.QueryRow(ctx,
+			`INSERT INTO question (bank_id, prompt, answer_bank, correct_answer) VALUES ($1, $2, $3, $4) RETURNING id`,
+			id, q.Prompt, q.AnswerBank, q.CorrectAnswer,
+		).Scan(&q.ID); err != nil {
+			return nil, translateError(err)
+		}
+		b.Questions = append(b.Questions, q)
+	}
+
+	return b, tx.Commit(ctx)
+}
+
+func (db *Database) DeleteBank(ctx context.Context, id int) error {
+	tag, err := db.Exec(ctx, `DELETE FROM bank WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
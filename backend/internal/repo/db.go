@@ -2,28 +2,126 @@ package repo
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-const schema = `
-	CREATE TABLE bank (
+// migrations upgrade the schema one version at a time, a database remembers
+// the last version it reached in schema_version. Databases created before
+// versioning start from version 0, so every statement has to be safe to run
+// on tables that may already exist
+var migrations = []string{
+	// 1: host accounts, sessions and recorded games
+	`
+	CREATE TABLE IF NOT EXISTS host_user (
+		id SERIAL PRIMARY KEY,
+		email TEXT NOT NULL UNIQUE,
+		password_hash TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+
+	CREATE TABLE IF NOT EXISTS session (
+		token_hash TEXT PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES host_user(id) ON DELETE CASCADE,
+		expires_at TIMESTAMPTZ NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS bank (
 		id SERIAL PRIMARY KEY,
 		title TEXT NOT NULL
-	)
+	);
+	ALTER TABLE bank ADD COLUMN IF NOT EXISTS owner_id INTEGER REFERENCES host_user(id) ON DELETE CASCADE;
 
-	CREATE TABLE question (
+	CREATE TABLE IF NOT EXISTS question (
+		id SERIAL PRIMARY KEY,
 		bank_id	INTEGER,
 		prompt TEXT NOT NULL,
 		answer_bank TEXT[],
+		correct_answer TEXT NOT NULL,
 		next_question INTEGER,
 		FOREIGN KEY (bank_id) REFERENCES bank(id) ON DELETE CASCADE,
 		FOREIGN KEY (next_question) REFERENCES question(id)
-	)
-`
+	);
+	ALTER TABLE question ADD COLUMN IF NOT EXISTS correct_answer TEXT NOT NULL DEFAULT '';
+
+	CREATE TABLE IF NOT EXISTS game (
+		id SERIAL PRIMARY KEY,
+		owner_id INTEGER REFERENCES host_user(id) ON DELETE CASCADE,
+		bank_id INTEGER REFERENCES bank(id) ON DELETE SET NULL,
+		room_code TEXT NOT NULL,
+		started_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		finished_at TIMESTAMPTZ
+	);
+	`,
+
+	// 2: single sign-on identities
+	`
+	CREATE TABLE IF NOT EXISTS host_identity (
+		issuer TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		user_id INTEGER NOT NULL REFERENCES host_user(id) ON DELETE CASCADE,
+		PRIMARY KEY (issuer, subject)
+	);
+	`,
+
+	// 3: game results and personal API tokens
+	`
+	CREATE TABLE IF NOT EXISTS game_result (
+		game_id INTEGER NOT NULL REFERENCES game(id) ON DELETE CASCADE,
+		player_id TEXT NOT NULL,
+		nickname TEXT NOT NULL,
+		points INTEGER NOT NULL,
+		team_id TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (game_id, player_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS api_token (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES host_user(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		scopes TEXT[] NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		last_used_at TIMESTAMPTZ,
+		revoked_at TIMESTAMPTZ
+	);
+	`,
+
+	// 4: organizations sharing banks
+	`
+	CREATE TABLE IF NOT EXISTS organization (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+
+	CREATE TABLE IF NOT EXISTS org_member (
+		org_id INTEGER NOT NULL REFERENCES organization(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES host_user(id) ON DELETE CASCADE,
+		role TEXT NOT NULL,
+		PRIMARY KEY (org_id, user_id)
+	);
+
+	ALTER TABLE bank ADD COLUMN IF NOT EXISTS org_id INTEGER REFERENCES organization(id) ON DELETE CASCADE;
+	ALTER TABLE game ADD COLUMN IF NOT EXISTS org_id INTEGER REFERENCES organization(id) ON DELETE SET NULL;
+	`,
+}
+
+// MIGRATION_LOCK keeps servers starting at the same time from migrating concurrently
+const MIGRATION_LOCK = 4242
+
+// ErrNotFound is returned when a queried row does not exist
+var ErrNotFound = errors.New("not found")
+
+// ErrConflict is returned when a row violates a unique constraint
+var ErrConflict = errors.New("already exists")
 
 type Database struct {
-	*pgx.Conn
+	*pgxpool.Pool
 	url                string
 	maxOpenConnections int
 }
@@ -36,34 +134,77 @@ func NewDatabase(url string, maxOpenConnections int) *Database {
 	return db
 }
 
-// Connect to a database at Database.url
-// If database file does not exist, a new db file will be created at Database.url
+// Connect opens a pool of at most Database.maxOpenConnections connections to the database at Database.url
 func (db *Database) Connect(ctx context.Context) error {
-	var err error
+	config, err := pgxpool.ParseConfig(db.url)
+	if err != nil {
+		return err
+	}
+	config.MaxConns = int32(db.maxOpenConnections)
+	config.ConnConfig.Tracer = queryTracer{}
 
-	db.Conn, err = pgx.Connect(context.Background(), db.url)
+	db.Pool, err = pgxpool.NewWithConfig(ctx, config)
 	if err != nil {
 		return err
 	}
-	defer db.Close(context.Background())
 
-	return nil
+	return db.Ping(ctx)
 }
 
-// CreateTables creates and executes database schema defined
-func (db *Database) CreateTables() error {
-	if _, err := db.Exec(context.Background(), schema); err != nil {
-		return err
+// Migrate brings the schema up to the latest version, running only the
+// migrations the database has not seen yet and returning how many ran
+func (db *Database) Migrate(ctx context.Context) (int, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, MIGRATION_LOCK); err != nil {
+		return 0, err
 	}
-	return nil
+
+	if _, err := tx.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)`); err != nil {
+		return 0, err
+	}
+
+	var version int
+	if err := tx.QueryRow(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version); err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for ; version < len(migrations); version++ {
+		if _, err := tx.Exec(ctx, migrations[version]); err != nil {
+			return 0, fmt.Errorf("migration %d: %w", version+1, err)
+		}
+		applied++
+	}
+
+	if applied == 0 {
+		return 0, nil
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM schema_version`); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(ctx, `INSERT INTO schema_version (version) VALUES ($1)`, version); err != nil {
+		return 0, err
+	}
+
+	return applied, tx.Commit(ctx)
 }
 
-// PingTable checks if database schema has been created by pinging bank table
-func (db *Database) PingTable() (bool, error) {
-	var tableExists bool
-	if err := db.QueryRow(context.Background(), `SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_schema = 'public' AND table_name = 'bank');`).Scan(&tableExists); err != nil {
-		return false, err
+// translateError maps driver errors to the errors exposed by this package
+func translateError(err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrConflict
 	}
 
-	return tableExists, nil
+	return err
 }
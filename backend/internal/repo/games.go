@@ -0,0 +1,127 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// CreateGame records a game hosted by a user, bankID may be 0 for the built-in example bank.
+// Games of organization banks are recorded for the organization as well
+func (db *Database) CreateGame(ctx context.Context, ownerID int, bankID int, roomCode string) (int, error) {
+	var nullableBank *int
+	if bankID > 0 {
+		nullableBank = &bankID
+	}
+
+	var id int
+	err := db.QueryRow(ctx,
+		`INSERT INTO game (owner_id, bank_id, org_id, room_code)
+		VALUES ($1, $2, (SELECT org_id FROM bank WHERE id = $2), $3) RETURNING id`,
+		ownerID, nullableBank, roomCode,
+	).Scan(&id)
+	if err != nil {
+		return 0, translateError(err)
+	}
+
+	return id, nil
+}
+
+// FinishGame marks a game as finished
+func (db *Database) FinishGame(ctx context.Context, id int) error {
+	_, err := db.Exec(ctx, `UPDATE game SET finished_at = now() WHERE id = $1`, id)
+	return translateError(err)
+}
+
+// Game is a recorded game hosted by a user
+type Game struct {
+	ID         int        `json:"id"`
+	OwnerID    int        `json:"ownerId"`
+	BankID     *int       `json:"bankId"`
+	OrgID      *int       `json:"organizationId"`
+	RoomCode   string     `json:"roomCode"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt"`
+}
+
+// Result is the final score of a player in a game
+type Result struct {
+	PlayerID string `json:"playerId"`
+	Nickname string `json:"nickname"`
+	Points   int    `json:"points"`
+	TeamID   string `json:"teamId,omitempty"`
+}
+
+// SaveResults stores the final scores of a game
+func (db *Database) SaveResults(ctx context.Context, gameID int, results []Result) error {
+	batch := &pgx.Batch{}
+	for _, r := range results {
+		batch.Queue(
+			`INSERT INTO game_result (game_id, player_id, nickname, points, team_id) VALUES ($1, $2, $3, $4, $5)`,
+			gameID, r.PlayerID, r.Nickname, r.Points, r.TeamID,
+		)
+	}
+
+	return db.SendBatch(ctx, batch).Close()
+}
+
+// ListGames returns the games a user hosted and the games of organizations
+// where they are allowed to change banks
+func (db *Database) ListGames(ctx context.Context, userID int) ([]Game, error) {
+	rows, err := db.Query(ctx,
+		`SELECT id, owner_id, bank_id, org_id, room_code, started_at, finished_at
+		FROM game WHERE owner_id = $1
+		OR org_id IN (SELECT org_id FROM org_member WHERE user_id = $1 AND role IN ($2, $3))
+		ORDER BY started_at DESC`, userID, ROLE_OWNER, ROLE_EDITOR,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	games := []Game{}
+	for rows.Next() {
+		var g Game
+		if err := rows.Scan(&g.ID, &g.OwnerID, &g.BankID, &g.OrgID, &g.RoomCode, &g.StartedAt, &g.FinishedAt); err != nil {
+			return nil, err
+		}
+		games = append(games, g)
+	}
+
+	return games, rows.Err()
+}
+
+func (db *Database) GetGame(ctx context.Context, id int) (*Game, error) {
+	g := &Game{}
+	err := db.QueryRow(ctx,
+		`SELECT id, owner_id, bank_id, org_id, room_code, started_at, finished_at FROM game WHERE id = $1`, id,
+	).Scan(&g.ID, &g.OwnerID, &g.BankID, &g.OrgID, &g.RoomCode, &g.StartedAt, &g.FinishedAt)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return g, nil
+}
+
+// GetResults returns the scores of a game sorted by points
+func (db *Database) GetResults(ctx context.Context, gameID int) ([]Result, error) {
+	rows, err := db.Query(ctx,
+		`SELECT player_id, nickname, points, team_id FROM game_result WHERE game_id = $1 ORDER BY points DESC`, gameID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []Result{}
+	for rows.Next() {
+		var r Result
+		if err := rows.Scan(&r.PlayerID, &r.Nickname, &r.Points, &r.TeamID); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+
+	return results, rows.Err()
+}
@@ -0,0 +1,30 @@
+package repo
+
+import (
+	"context"
+)
+
+// GetUserByIdentity returns the host account linked to an identity provider subject
+func (db *Database) GetUserByIdentity(ctx context.Context, issuer string, subject string) (*User, error) {
+	u := &User{}
+	err := db.QueryRow(ctx,
+		`SELECT u.id, u.email, u.password_hash, u.created_at
+		FROM host_identity i JOIN host_user u ON u.id = i.user_id
+		WHERE i.issuer = $1 AND i.subject = $2`,
+		issuer, subject,
+	).Scan(&u.ID, &u.Email, &u.PasswordHash, &u.CreatedAt)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return u, nil
+}
+
+// LinkIdentity lets a host account log in through an identity provider subject
+func (db *Database) LinkIdentity(ctx context.Context, issuer string, subject string, userID int) error {
+	_, err := db.Exec(ctx,
+		`INSERT INTO host_identity (issuer, subject, user_id) VALUES ($1, $2, $3)`,
+		issuer, subject, userID,
+	)
+	return translateError(err)
+}
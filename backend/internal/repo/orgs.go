@@ -0,0 +1,179 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Roles of organization members, from most to least privileged
+const (
+	ROLE_OWNER  = "owner"  // manages members and deletes banks
+	ROLE_EDITOR = "editor" // creates and changes banks
+	ROLE_VIEWER = "viewer" // reads and hosts banks
+)
+
+var roleRank = map[string]int{
+	ROLE_VIEWER: 1,
+	ROLE_EDITOR: 2,
+	ROLE_OWNER:  3,
+}
+
+// ValidRole reports whether role can be given to a member
+func ValidRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// RoleAllows reports whether role grants at least the permissions of required,
+// an empty role grants nothing
+func RoleAllows(role string, required string) bool {
+	return roleRank[role] > 0 && roleRank[role] >= roleRank[required]
+}
+
+// Organization is a workspace whose members share banks
+type Organization struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role"` // role of the user the organization was listed for
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type Member struct {
+	UserID int    `json:"userId"`
+	Email  string `json:"email"`
+	Role   string `json:"role"`
+}
+
+// CreateOrganization stores an organization with ownerID as its first owner
+func (db *Database) CreateOrganization(ctx context.Context, ownerID int, name string) (*Organization, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	o := &Organization{
+		Name: name,
+		Role: ROLE_OWNER,
+	}
+
+	if err := tx.QueryRow(ctx,
+		`INSERT INTO organization (name) VALUES ($1) RETURNING id, created_at`, name,
+	).Scan(&o.ID, &o.CreatedAt); err != nil {
+		return nil, translateError(err)
+	}
+
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO org_member (org_id, user_id, role) VALUES ($1, $2, $3)`,
+		o.ID, ownerID, ROLE_OWNER,
+	); err != nil {
+		return nil, translateError(err)
+	}
+
+	return o, tx.Commit(ctx)
+}
+
+// ListOrganizations returns the organizations a user is a member of
+func (db *Database) ListOrganizations(ctx context.Context, userID int) ([]Organization, error) {
+	rows, err := db.Query(ctx,
+		`SELECT o.id, o.name, m.role, o.created_at
+		FROM organization o JOIN org_member m ON m.org_id = o.id
+		WHERE m.user_id = $1 ORDER BY o.id`, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orgs := []Organization{}
+	for rows.Next() {
+		var o Organization
+		if err := rows.Scan(&o.ID, &o.Name, &o.Role, &o.CreatedAt); err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, o)
+	}
+
+	return orgs, rows.Err()
+}
+
+// MemberRole returns the role of a user in an organization, or an empty string if they are not a member
+func (db *Database) MemberRole(ctx context.Context, orgID int, userID int) (string, error) {
+	var role string
+	err := db.QueryRow(ctx,
+		`SELECT role FROM org_member WHERE org_id = $1 AND user_id = $2`, orgID, userID,
+	).Scan(&role)
+	if err := translateError(err); err != nil && !errors.Is(err, ErrNotFound) {
+		return "", err
+	}
+
+	return role, nil
+}
+
+func (db *Database) ListMembers(ctx context.Context, orgID int) ([]Member, error) {
+	rows, err := db.Query(ctx,
+		`SELECT m.user_id, u.email, m.role
+		FROM org_member m JOIN host_user u ON u.id = m.user_id
+		WHERE m.org_id = $1 ORDER BY m.user_id`, orgID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []Member{}
+	for rows.Next() {
+		var m Member
+		if err := rows.Scan(&m.UserID, &m.Email, &m.Role); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+
+	return members, rows.Err()
+}
+
+// SetMember adds a user to an organization or changes their role
+func (db *Database) SetMember(ctx context.Context, orgID int, userID int, role string) error {
+	_, err := db.Exec(ctx,
+		`INSERT INTO org_member (org_id, user_id, role) VALUES ($1, $2, $3)
+		ON CONFLICT (org_id, user_id) DO UPDATE SET role = EXCLUDED.role`,
+		orgID, userID, role,
+	)
+	return translateError(err)
+}
+
+func (db *Database) RemoveMember(ctx context.Context, orgID int, userID int) error {
+	tag, err := db.Exec(ctx, `DELETE FROM org_member WHERE org_id = $1 AND user_id = $2`, orgID, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// CountOwners returns how many owners an organization has
+func (db *Database) CountOwners(ctx context.Context, orgID int) (int, error) {
+	var count int
+	err := db.QueryRow(ctx,
+		`SELECT count(*) FROM org_member WHERE org_id = $1 AND role = $2`, orgID, ROLE_OWNER,
+	).Scan(&count)
+	return count, err
+}
+
+// BankRole returns the role a user has on a bank. Personal banks are owned by
+// their creator, banks of an organization follow the user's role in it.
+// An empty string means the user has no access to the bank
+func (db *Database) BankRole(ctx context.Context, bank *Bank, userID int) (string, error) {
+	if bank.OrgID == nil {
+		if bank.OwnerID != nil && *bank.OwnerID == userID {
+			return ROLE_OWNER, nil
+		}
+		return "", nil
+	}
+
+	return db.MemberRole(ctx, *bank.OrgID, userID)
+}
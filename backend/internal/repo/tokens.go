@@ -0,0 +1,96 @@
+package repo
+
+import (
+	"context"
+	"time"
+)
+
+// APIToken is a personal access token, only its hash is stored
+type APIToken struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"-"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+}
+
+func (db *Database) CreateAPIToken(ctx context.Context, userID int, name string, tokenHash string, scopes []string) (*APIToken, error) {
+	t := &APIToken{
+		UserID: userID,
+		Name:   name,
+		Scopes: scopes,
+	}
+
+	err := db.QueryRow(ctx,
+		`INSERT INTO api_token (user_id, name, token_hash, scopes) VALUES ($1, $2, $3, $4) RETURNING id, created_at`,
+		userID, name, tokenHash, scopes,
+	).Scan(&t.ID, &t.CreatedAt)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return t, nil
+}
+
+func (db *Database) ListAPITokens(ctx context.Context, userID int) ([]APIToken, error) {
+	rows, err := db.Query(ctx,
+		`SELECT id, user_id, name, scopes, created_at, last_used_at, revoked_at
+		FROM api_token WHERE user_id = $1 ORDER BY id`, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []APIToken{}
+	for rows.Next() {
+		var t APIToken
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Name, &t.Scopes, &t.CreatedAt, &t.LastUsedAt, &t.RevokedAt); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+
+	return tokens, rows.Err()
+}
+
+// RevokeAPIToken revokes a token of a user, returning ErrNotFound if the user has no such active token
+func (db *Database) RevokeAPIToken(ctx context.Context, userID int, id int) error {
+	tag, err := db.Exec(ctx,
+		`UPDATE api_token SET revoked_at = now() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`,
+		id, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// UseAPIToken looks up an active token by hash, records its use and returns its owner
+func (db *Database) UseAPIToken(ctx context.Context, tokenHash string) (*APIToken, *User, error) {
+	t := &APIToken{}
+	u := &User{}
+
+	err := db.QueryRow(ctx,
+		`WITH used AS (
+			UPDATE api_token SET last_used_at = now()
+			WHERE token_hash = $1 AND revoked_at IS NULL
+			RETURNING id, user_id, name, scopes, created_at, last_used_at
+		)
+		SELECT used.id, used.user_id, used.name, used.scopes, used.created_at, used.last_used_at,
+			u.id, u.email, u.password_hash, u.created_at
+		FROM used JOIN host_user u ON u.id = used.user_id`,
+		tokenHash,
+	).Scan(&t.ID, &t.UserID, &t.Name, &t.Scopes, &t.CreatedAt, &t.LastUsedAt,
+		&u.ID, &u.Email, &u.PasswordHash, &u.CreatedAt)
+	if err != nil {
+		return nil, nil, translateError(err)
+	}
+
+	return t, u, nil
+}
@@ -0,0 +1,91 @@
+package repo
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/enzofalone/kahoot/internal/metrics"
+	"github.com/jackc/pgx/v5"
+)
+
+type queryStartKey struct{}
+
+type queryStart struct {
+	at        time.Time
+	operation string
+	table     string
+}
+
+// queryTracer records the duration of every query and batch in metrics.QueryDuration
+type queryTracer struct{}
+
+func (queryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return startQuery(ctx, data.SQL)
+}
+
+func (queryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryEndData) {
+	endQuery(ctx)
+}
+
+// batches are measured as a whole and labelled after their first query
+func (queryTracer) TraceBatchStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchStartData) context.Context {
+	sql := ""
+	if data.Batch != nil && len(data.Batch.QueuedQueries) > 0 {
+		sql = data.Batch.QueuedQueries[0].SQL
+	}
+	return startQuery(ctx, sql)
+}
+
+func (queryTracer) TraceBatchQuery(context.Context, *pgx.Conn, pgx.TraceBatchQueryData) {}
+
+func (queryTracer) TraceBatchEnd(ctx context.Context, _ *pgx.Conn, _ pgx.TraceBatchEndData) {
+	endQuery(ctx)
+}
+
+func startQuery(ctx context.Context, sql string) context.Context {
+	operation, table := describeQuery(sql)
+	return context.WithValue(ctx, queryStartKey{}, queryStart{
+		at:        time.Now(),
+		operation: operation,
+		table:     table,
+	})
+}
+
+func endQuery(ctx context.Context) {
+	start, ok := ctx.Value(queryStartKey{}).(queryStart)
+	if !ok {
+		return
+	}
+	metrics.QueryDuration.WithLabelValues(start.operation, start.table).Observe(time.Since(start.at).Seconds())
+}
+
+// describeQuery returns the statement type and the first table of a query,
+// keeping metric labels bounded to the queries of this package
+func describeQuery(sql string) (string, string) {
+	words := strings.Fields(strings.ToLower(sql))
+	if len(words) == 0 {
+		return "unknown", "unknown"
+	}
+
+	operation := words[0]
+	marker := "from"
+	switch operation {
+	case "insert":
+		marker = "into"
+	case "update":
+		if len(words) > 1 {
+			return operation, words[1]
+		}
+	case "select", "delete":
+	default:
+		return operation, "unknown"
+	}
+
+	for i, word := range words[:len(words)-1] {
+		if word == marker {
+			return operation, strings.Trim(words[i+1], "(),;")
+		}
+	}
+	return operation, "unknown"
+}
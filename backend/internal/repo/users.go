@@ -0,0 +1,75 @@
+package repo
+
+import (
+	"context"
+	"time"
+)
+
+// User is a host account
+type User struct {
+	ID           int
+	Email        string
+	PasswordHash string
+	CreatedAt    time.Time
+}
+
+// CreateUser stores a new host account, returning ErrConflict if the email is taken
+func (db *Database) CreateUser(ctx context.Context, email string, passwordHash string) (*User, error) {
+	u := &User{
+		Email:        email,
+		PasswordHash: passwordHash,
+	}
+
+	err := db.QueryRow(ctx,
+		`INSERT INTO host_user (email, password_hash) VALUES ($1, $2) RETURNING id, created_at`,
+		email, passwordHash,
+	).Scan(&u.ID, &u.CreatedAt)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return u, nil
+}
+
+func (db *Database) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	u := &User{}
+	err := db.QueryRow(ctx,
+		`SELECT id, email, password_hash, created_at FROM host_user WHERE email = $1`,
+		email,
+	).Scan(&u.ID, &u.Email, &u.PasswordHash, &u.CreatedAt)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return u, nil
+}
+
+// CreateSession stores the hash of a session token for a user
+func (db *Database) CreateSession(ctx context.Context, tokenHash string, userID int, expiresAt time.Time) error {
+	_, err := db.Exec(ctx,
+		`INSERT INTO session (token_hash, user_id, expires_at) VALUES ($1, $2, $3)`,
+		tokenHash, userID, expiresAt,
+	)
+	return translateError(err)
+}
+
+// GetSessionUser returns the owner of a session that has not expired yet
+func (db *Database) GetSessionUser(ctx context.Context, tokenHash string) (*User, error) {
+	u := &User{}
+	err := db.QueryRow(ctx,
+		`SELECT u.id, u.email, u.password_hash, u.created_at
+		FROM session s JOIN host_user u ON u.id = s.user_id
+		WHERE s.token_hash = $1 AND s.expires_at > now()`,
+		tokenHash,
+	).Scan(&u.ID, &u.Email, &u.PasswordHash, &u.CreatedAt)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return u, nil
+}
+
+func (db *Database) DeleteSession(ctx context.Context, tokenHash string) error {
+	_, err := db.Exec(ctx, `DELETE FROM session WHERE token_hash = $1`, tokenHash)
+	return translateError(err)
+}
@@ -0,0 +1,72 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/enzofalone/kahoot/internal/repo"
+)
+
+// exampleBank is played when a host does not pick one of their banks
+func exampleBank() *Bank {
+	questions := []Question{
+		{
+			Prompt:        "What is 2 + 2?",
+			AnswerBank:    []string{"3", "4", "5", "6"},
+			CorrectAnswer: "4",
+		},
+		{
+			Prompt:        "Which planet is closest to the Sun?",
+			AnswerBank:    []string{"Venus", "Mars", "Mercury", "Earth"},
+			CorrectAnswer: "Mercury",
+		},
+		{
+			Prompt:        "What color is a banana?",
+			AnswerBank:    []string{"Red", "Green", "Yellow", "Blue"},
+			CorrectAnswer: "Yellow",
+		},
+	}
+
+	return &Bank{
+		ID:        0,
+		Title:     "Example Bank",
+		Questions: questions,
+	}
+}
+
+// loadBank fetches a bank from the database, only if userID is allowed to host it
+func loadBank(ctx context.Context, db *repo.Database, bankID int, userID int) (*Bank, error) {
+	stored, err := db.GetBank(ctx, bankID)
+	if err != nil {
+		return nil, err
+	}
+
+	role, err := db.BankRole(ctx, stored, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !repo.RoleAllows(role, repo.ROLE_VIEWER) {
+		return nil, repo.ErrNotFound
+	}
+
+	if len(stored.Questions) == 0 {
+		return nil, fmt.Errorf("bank %d has no questions", bankID)
+	}
+
+	b := &Bank{
+		ID:        stored.ID,
+		Title:     stored.Title,
+		Questions: make([]Question, 0, len(stored.Questions)),
+	}
+	for _, q := range stored.Questions {
+		b.Questions = append(b.Questions, Question{
+			ID:            q.ID,
+			BankID:        q.BankID,
+			Prompt:        q.Prompt,
+			AnswerBank:    q.AnswerBank,
+			CorrectAnswer: q.CorrectAnswer,
+		})
+	}
+
+	return b, nil
+}
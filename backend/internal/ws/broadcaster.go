@@ -2,78 +2,335 @@ package ws
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"sync"
+	"time"
 
 	"github.com/coder/websocket"
+	"github.com/enzofalone/kahoot/internal/metrics"
 )
 
-// Broadcaster handles WebSocket message broadcasting
+var (
+	errNotRegistered = errors.New("connection is not registered")
+	errClosed        = errors.New("connection is closed")
+	errSlowConsumer  = errors.New("connection is too slow, send queue is full")
+	errNotReceiver   = errors.New("event is not sent to the role of the connection")
+)
+
+// Broadcaster handles WebSocket message broadcasting. Every connection gets a
+// bounded queue drained by its own writer, so sending never waits on a client
+// and a stalled client cannot hold back the rest of its room. Clients that let
+// their queue fill up are dropped.
 type Broadcaster struct {
-	logf func(format string, args ...interface{})
+	logger       *slog.Logger
+	queueSize    int
+	writeTimeout time.Duration
+
+	mu       sync.Mutex
+	outboxes map[*websocket.Conn]*outbox
+}
+
+// outbox is the send queue of a single connection
+type outbox struct {
+	conn     *websocket.Conn
+	logger   *slog.Logger
+	role     string // of the client, only events the registry sends to it are queued
+	encoding string // the connection negotiated, see frame
+	queue    chan outboundMessage
+	done     chan struct{} // closed once the writer stopped
+	stop     chan struct{} // closed to stop the writer without flushing
+	once     sync.Once
 }
 
-func NewBroadcaster(logf func(format string, args ...interface{})) *Broadcaster {
+// outboundMessage is either a message to write or, once close is set, the
+// status the connection is closed with after every earlier message is written
+type outboundMessage struct {
+	frame  *frame
+	close  bool
+	status websocket.StatusCode
+	reason string
+}
+
+func NewBroadcaster(logger *slog.Logger, limits Limits) *Broadcaster {
 	return &Broadcaster{
-		logf: logf,
+		logger:       logger,
+		queueSize:    limits.SendQueue,
+		writeTimeout: limits.WriteTimeout,
+		outboxes:     make(map[*websocket.Conn]*outbox),
+	}
+}
+
+// Add starts the writer of a connection, it must be called before anything is
+// sent to it. Messages are written in the encoding the connection negotiated,
+// only events the registry sends to role are accepted and failures of the
+// connection are reported with logger
+func (b *Broadcaster) Add(conn *websocket.Conn, role string, protocol Protocol, logger *slog.Logger) {
+	o := &outbox{
+		conn:     conn,
+		logger:   logger,
+		role:     role,
+		encoding: protocol.Encoding,
+		queue:    make(chan outboundMessage, b.queueSize),
+		done:     make(chan struct{}),
+		stop:     make(chan struct{}),
+	}
+
+	b.mu.Lock()
+	b.outboxes[conn] = o
+	b.mu.Unlock()
+
+	go b.write(o)
+}
+
+// Remove stops the writer of a connection, discarding anything still queued
+func (b *Broadcaster) Remove(conn *websocket.Conn) {
+	if o := b.take(conn); o != nil {
+		o.once.Do(func() { close(o.stop) })
+		<-o.done
+	}
+}
+
+// Close closes a connection once every message queued before it is written,
+// waiting until the connection is closed
+func (b *Broadcaster) Close(conn *websocket.Conn, status websocket.StatusCode, reason string) {
+	o := b.take(conn)
+	if o == nil {
+		conn.Close(status, reason)
+		return
+	}
+
+	select {
+	case o.queue <- outboundMessage{close: true, status: status, reason: reason}:
+	default:
+		// the client is not keeping up, no point in flushing the queue
+		o.once.Do(func() { close(o.stop) })
+		<-o.done
+		conn.Close(status, reason)
+		return
 	}
+	<-o.done
+}
+
+// take unregisters a connection, returning its outbox if it had one
+func (b *Broadcaster) take(conn *websocket.Conn) *outbox {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	o, exists := b.outboxes[conn]
+	if !exists {
+		return nil
+	}
+	delete(b.outboxes, conn)
+	return o
+}
+
+// write drains the queue of a connection until it is stopped, closed or fails
+func (b *Broadcaster) write(o *outbox) {
+	defer close(o.done)
+
+	for {
+		select {
+		case <-o.stop:
+			return
+		case m := <-o.queue:
+			if m.close {
+				o.conn.Close(m.status, m.reason)
+				return
+			}
+
+			data, typ, err := m.frame.encode(o.encoding)
+			if err != nil {
+				metrics.BroadcastFailures.Inc()
+				o.logger.Error("broadcaster: failed to encode message", "encoding", o.encoding, "err", err)
+				continue
+			}
+
+			if err := b.writeMessage(o.conn, typ, data); err != nil {
+				metrics.BroadcastFailures.Inc()
+				o.logger.Warn("broadcaster: failed to write, dropping connection", "err", err)
+				b.take(o.conn)
+				o.conn.CloseNow()
+				return
+			}
+			countSent(m.frame.event.name(), 1)
+		}
+	}
+}
+
+// writeMessage writes a single message, giving up after the write timeout
+func (b *Broadcaster) writeMessage(conn *websocket.Conn, typ websocket.MessageType, message []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), b.writeTimeout)
+	defer cancel()
+
+	return conn.Write(ctx, typ, message)
 }
 
-// SendTo sends a message to a connection
-func (b *Broadcaster) SendTo(conn *websocket.Conn, message []byte) error {
-	w, err := conn.Writer(context.Background(), websocket.MessageText)
-	if err != nil {
-		return fmt.Errorf("failed to get writer for host: %v", err)
+// enqueue queues a message without waiting, a connection whose queue is full
+// is closed so it stops holding messages for the rest of the room
+func (b *Broadcaster) enqueue(conn *websocket.Conn, f *frame) error {
+	b.mu.Lock()
+	o, exists := b.outboxes[conn]
+	b.mu.Unlock()
+	if !exists {
+		metrics.BroadcastFailures.Inc()
+		return errNotRegistered
 	}
-	defer w.Close()
 
-	if _, err := w.Write(message); err != nil {
-		return fmt.Errorf("failed to write to host: %v", err)
+	if !mayReceive(o.role, f.event.name()) {
+		metrics.BroadcastFailures.Inc()
+		o.logger.Error("broadcaster: refusing event the role does not receive", LOG_EVENT, f.event.name())
+		return errNotReceiver
+	}
+
+	// encoded by the sender, once per encoding, so the writer never reads an
+	// event the game may still be changing
+	f.encode(o.encoding)
+
+	select {
+	case <-o.done:
+		metrics.BroadcastFailures.Inc()
+		return errClosed
+	case o.queue <- outboundMessage{frame: f}:
+		return nil
+	default:
+	}
+
+	metrics.BroadcastFailures.Inc()
+	metrics.SlowConsumers.Inc()
+	o.logger.Warn("broadcaster: send queue is full, dropping connection", "queued", len(o.queue))
+	if b.take(conn) != nil {
+		o.once.Do(func() { close(o.stop) })
+		go conn.Close(websocket.StatusPolicyViolation, "Connection too slow")
+	}
+	return errSlowConsumer
+}
+
+// SendTo queues an event for a connection
+func (b *Broadcaster) SendTo(conn *websocket.Conn, e Outgoing) error {
+	if err := b.enqueue(conn, newFrame(e)); err != nil {
+		return fmt.Errorf("failed to send: %v", err)
 	}
 	return nil
 }
 
-// SendToArray sends a message to the array of connections
-func (b *Broadcaster) SendToArray(conns []*websocket.Conn, message []byte) error {
-	for i := 0; i < len(conns); i++ {
-		w, err := conns[i].Writer(context.Background(), websocket.MessageText)
-		if err != nil {
-			return fmt.Errorf("failed to get writer for host: %v", err)
+// sendBurst queues several frames for a connection in order. Unlike the
+// other sends it waits for room in the queue, up to the write timeout for
+// each frame, so bursts larger than the queue such as replays go through
+func (b *Broadcaster) sendBurst(conn *websocket.Conn, frames []*frame) error {
+	b.mu.Lock()
+	o, exists := b.outboxes[conn]
+	b.mu.Unlock()
+	if !exists {
+		return fmt.Errorf("failed to send: %v", errNotRegistered)
+	}
+
+	for _, f := range frames {
+		if !mayReceive(o.role, f.event.name()) {
+			metrics.BroadcastFailures.Inc()
+			o.logger.Error("broadcaster: refusing event the role does not receive", LOG_EVENT, f.event.name())
+			continue
 		}
-		defer w.Close()
 
-		if _, err := w.Write(message); err != nil {
-			return fmt.Errorf("failed to write to host: %v", err)
+		f.encode(o.encoding)
+		timeout := time.NewTimer(b.writeTimeout)
+		select {
+		case o.queue <- outboundMessage{frame: f}:
+			timeout.Stop()
+		case <-o.done:
+			timeout.Stop()
+			metrics.BroadcastFailures.Inc()
+			return fmt.Errorf("failed to send: %v", errClosed)
+		case <-timeout.C:
+			metrics.BroadcastFailures.Inc()
+			return fmt.Errorf("failed to send: %v", errSlowConsumer)
 		}
 	}
 	return nil
 }
 
-// BroadcastToPlayers sends a message to all player connections
-func (b *Broadcaster) BroadcastToPlayers(players []*Player, message []byte) error {
+// SendToArray queues an event for every connection of the array, returning
+// the failures of every connection that could not get it
+func (b *Broadcaster) SendToArray(conns []*websocket.Conn, e Outgoing) error {
+	f := newFrame(e)
+
+	var errs []error
+	for i, conn := range conns {
+		if err := b.enqueue(conn, f); err != nil {
+			errs = append(errs, fmt.Errorf("connection %d: %v", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// BroadcastToPlayers queues an event for all player connections
+func (b *Broadcaster) BroadcastToPlayers(players []*Player, e Outgoing) error {
+	return b.sendToPlayers(players, newFrame(e))
+}
+
+func (b *Broadcaster) sendToPlayers(players []*Player, f *frame) error {
 	for _, player := range players {
-		w, err := player.Conn.Writer(context.Background(), websocket.MessageText)
-		if err != nil {
-			b.logf("broadcast: failed to get writer for player %s: %v", player.ID, err)
-			continue
+		if err := b.enqueue(player.Conn, f); err != nil {
+			b.logger.Warn("broadcast: failed to send", LOG_PLAYER, player.ID, "err", err)
 		}
-		defer w.Close()
+	}
+	return nil
+}
+
+// SendToHost sends an event to the host and every spectator of a room,
+// numbering it in the room's event log
+func (b *Broadcaster) SendToHost(room *Room, e Outgoing) error {
+	return b.sendToViewers(room, room.Events.record(e, viewerRoles))
+}
+
+// sendToViewers sends a frame to the host and every spectator of a room
+func (b *Broadcaster) sendToViewers(room *Room, f *frame) error {
+	if err := b.enqueue(room.HostConn, f); err != nil {
+		return fmt.Errorf("failed to send: %v", err)
+	}
 
-		if _, err := w.Write(message); err != nil {
-			b.logf("broadcast: failed to write to player %s: %v", player.ID, err)
+	for _, s := range room.spectators() {
+		if err := b.enqueue(s.Conn, f); err != nil {
+			b.logger.Warn("sendToHost: failed to send to spectator", LOG_ROOM, room.ID, "spectator", s.ID, "err", err)
 		}
 	}
 	return nil
 }
 
-// BroadcastAll sends a message to both host and players
-func (b *Broadcaster) BroadcastAll(hostConn *websocket.Conn, players []*Player, message []byte) error {
-	if err := b.SendTo(hostConn, message); err != nil {
-		b.logf("broadcastAll: failed to send to host: %v", err)
+// BroadcastAll sends an event to the host, spectators and players of a room,
+// numbering it in the room's event log
+func (b *Broadcaster) BroadcastAll(room *Room, e Outgoing) error {
+	start := time.Now()
+	defer func() {
+		metrics.BroadcastDuration.Observe(time.Since(start).Seconds())
+	}()
+
+	// encoded once for the whole room
+	f := room.Events.record(e, roomRoles)
+
+	if err := b.sendToViewers(room, f); err != nil {
+		b.logger.Error("broadcastAll: failed to send to host", LOG_ROOM, room.ID, "err", err)
 	}
 
-	if err := b.BroadcastToPlayers(players, message); err != nil {
-		b.logf("broadcastAll: failed to broadcast to players: %v", err)
+	if err := b.sendToPlayers(room.players(), f); err != nil {
+		b.logger.Error("broadcastAll: failed to broadcast to players", LOG_ROOM, room.ID, "err", err)
 	}
 
 	return nil
 }
+
+// SendToControllers sends an event to every controller of a room
+func (b *Broadcaster) SendToControllers(room *Room, e Outgoing) {
+	b.sendToControllers(room.ID, room.controllers(), e)
+}
+
+// sendToControllers sends an event to a snapshot of the controllers of a room
+func (b *Broadcaster) sendToControllers(roomID string, controllers []*Controller, e Outgoing) {
+	f := newFrame(e)
+	for _, c := range controllers {
+		if err := b.enqueue(c.Conn, f); err != nil {
+			b.logger.Warn("sendToControllers: failed to send", LOG_ROOM, roomID, "controller", c.ID, "err", err)
+		}
+	}
+}
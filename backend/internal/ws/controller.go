@@ -0,0 +1,159 @@
+package ws
+
+import (
+	"context"
+	"crypto/subtle"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/coder/websocket"
+	"github.com/enzofalone/kahoot/internal/config"
+	"github.com/enzofalone/kahoot/internal/metrics"
+)
+
+// Controller is a remote control of a room, such as the teacher's phone,
+// authenticated with the room's owner token
+type Controller struct {
+	ID   string
+	Conn *websocket.Conn
+}
+
+type ControllerHandler struct {
+	logger      *slog.Logger
+	rooms       *Rooms
+	host        *HostHandler
+	broadcaster *Broadcaster
+	codes       RoomCodeFormat
+	guard       *JoinGuard
+	cfg         *config.Config
+}
+
+func NewControllerHandler(logger *slog.Logger, cfg *config.Config, rooms *Rooms, broadcaster *Broadcaster, host *HostHandler, codes RoomCodeFormat, guard *JoinGuard) *ControllerHandler {
+	return &ControllerHandler{
+		logger:      logger,
+		rooms:       rooms,
+		host:        host,
+		broadcaster: broadcaster,
+		codes:       codes,
+		guard:       guard,
+		cfg:         cfg,
+	}
+}
+
+func (ch ControllerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	attempt, allowed := guardJoin(ch.guard, w, r)
+	if !allowed {
+		return
+	}
+	defer attempt.release()
+
+	c, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+		Subprotocols:   subprotocols(SUBPROTOCOL_CONTROLLER),
+		OriginPatterns: ch.cfg.Server.OriginPatterns(),
+	})
+	if err != nil {
+		ch.logger.Error("failed to accept websocket", "err", err)
+		return
+	}
+	defer c.CloseNow()
+
+	protocol, ok := negotiate(SUBPROTOCOL_CONTROLLER, c.Subprotocol())
+	if !ok {
+		c.Close(websocket.StatusPolicyViolation, "client must speak the kahoot-controller subprotocol")
+		return
+	}
+	ch.broadcaster.Add(c, metrics.ROLE_CONTROLLER, protocol, ch.logger.With("role", metrics.ROLE_CONTROLLER, "remote", r.RemoteAddr))
+	defer ch.broadcaster.Remove(c)
+
+	// browsers cannot set headers on websockets, so query parameters are accepted too
+	code := r.Header.Get("Room-ID")
+	if len(code) == 0 {
+		code = r.URL.Query().Get("room")
+	}
+	token := r.Header.Get("Room-Token")
+	if len(token) == 0 {
+		token = r.URL.Query().Get("token")
+	}
+
+	room, exists := ch.rooms.Get(ch.codes.Sanitize(code))
+	if !exists || subtle.ConstantTimeCompare([]byte(token), []byte(room.OwnerToken)) != 1 {
+		attempt.fail()
+		c.Close(websocket.StatusPolicyViolation, "Invalid room or token")
+		return
+	}
+	attempt.release()
+
+	controller := &Controller{
+		ID:   generatePlayerID(),
+		Conn: c,
+	}
+	logger := ch.logger.With(LOG_ROOM, room.ID, "controller", controller.ID)
+	room.addController(controller)
+	defer room.removeController(controller)
+	metrics.Connections.WithLabelValues(metrics.ROLE_CONTROLLER).Inc()
+	defer metrics.Connections.WithLabelValues(metrics.ROLE_CONTROLLER).Dec()
+
+	updateControllers(ch.broadcaster, room)
+
+	if ch.host.limits.MaxMessageSize > 0 {
+		c.SetReadLimit(ch.host.limits.MaxMessageSize)
+	}
+	limiter := newConnLimiter(ch.host.limits.HostEvents)
+
+	for {
+		_, reader, err := c.Reader(context.Background())
+		if err != nil {
+			logger.Error("Failed to get reader from controller", "err", err)
+			break
+		}
+
+		message, err := io.ReadAll(reader)
+		if err != nil {
+			logger.Error("Failed to read message from controller", "err", err)
+			break
+		}
+
+		if allowed, disconnect := limiter.allow(); disconnect {
+			logger.Warn("Controller exceeded the rate limit, disconnecting")
+			c.Close(websocket.StatusPolicyViolation, "Too many messages")
+			break
+		} else if !allowed {
+			// decoded only to tell the client which request was dropped
+			event, _ := decodeEvent(message, metrics.ROLE_CONTROLLER, protocol)
+			reply(ch.broadcaster, c, event, errRateLimited)
+			continue
+		}
+
+		// controllers can only drive the game flow, the registry rejects anything else
+		event, err := decodeEvent(message, metrics.ROLE_CONTROLLER, protocol)
+		if err != nil {
+			logger.Warn("Rejected event", LOG_EVENT, event.Name, "err", err)
+		} else {
+			err = ch.host.control(room, event.Name)
+		}
+		reply(ch.broadcaster, c, event, err)
+	}
+}
+
+// updateControllers sends the compact status of a room to all its controllers
+func updateControllers(b *Broadcaster, room *Room) {
+	controllers := room.controllers()
+	if len(controllers) == 0 {
+		return
+	}
+
+	e := &Event[ControllerStatus]{
+		Event: EVENT_STATUS,
+		Content: ControllerStatus{
+			Phase:          room.Question.State,
+			QuestionNumber: room.Question.Index,
+			TotalQuestions: len(room.Bank.Questions),
+			Players:        room.playerCount(),
+			Answered:       len(room.Question.Answers),
+			CanSkip:        room.Question.State == EVENT_QUESTION && !room.Skip.Used,
+		},
+	}
+
+	b.sendToControllers(room.ID, controllers, e)
+}
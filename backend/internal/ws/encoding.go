@@ -0,0 +1,70 @@
+package ws
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/coder/websocket"
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Encodings of the events of a connection, clients opt into CBOR by adding
+// +cbor to the subprotocol they offer, e.g. kahoot-player.v1+cbor
+const (
+	ENCODING_JSON = "json"
+	ENCODING_CBOR = "cbor"
+)
+
+// Outgoing is an event sent to clients, implemented by *Event. Frames keep
+// the typed event so every encoding is made straight from it
+type Outgoing interface {
+	name() string
+	withSeq(seq uint64) Outgoing
+}
+
+func (e *Event[T]) name() string {
+	return e.Event
+}
+
+// withSeq returns a copy of the event numbered seq, the original may still be
+// sent elsewhere while the copy is encoded
+func (e *Event[T]) withSeq(seq uint64) Outgoing {
+	stamped := *e
+	stamped.Seq = seq
+	return &stamped
+}
+
+// frame is an event along with its encodings. Each encoding is made once
+// however many connections the frame is sent to, and only if one of them
+// negotiated it
+type frame struct {
+	event Outgoing
+
+	jsonOnce sync.Once
+	json     []byte
+	jsonErr  error
+
+	cborOnce sync.Once
+	cbor     []byte
+	cborErr  error
+}
+
+func newFrame(e Outgoing) *frame {
+	return &frame{event: e}
+}
+
+// encode returns the frame in the encoding of a connection and the type of
+// websocket message to send it as. CBOR keys are the JSON names of the fields
+func (f *frame) encode(encoding string) ([]byte, websocket.MessageType, error) {
+	if encoding == ENCODING_CBOR {
+		f.cborOnce.Do(func() {
+			f.cbor, f.cborErr = cbor.Marshal(f.event)
+		})
+		return f.cbor, websocket.MessageBinary, f.cborErr
+	}
+
+	f.jsonOnce.Do(func() {
+		f.json, f.jsonErr = json.Marshal(f.event)
+	})
+	return f.json, websocket.MessageText, f.jsonErr
+}
@@ -0,0 +1,71 @@
+package ws
+
+import (
+	"fmt"
+	"testing"
+)
+
+// benchmarkEvents are typical events of a game, from a small ack to the
+// leaderboard of a full room
+func benchmarkEvents() []struct {
+	name  string
+	event Outgoing
+} {
+	scores := make([]PlayerScore, 10)
+	for i := range scores {
+		scores[i] = PlayerScore{
+			ID:       fmt.Sprintf("player-%02d", i),
+			Nickname: fmt.Sprintf("Player %d", i),
+			Points:   1000 - i*37,
+		}
+	}
+
+	return []struct {
+		name  string
+		event Outgoing
+	}{
+		{"ack", &Event[Ack]{
+			Event:   EVENT_ACK,
+			Content: Ack{RequestID: "42", Event: EVENT_ANSWER},
+		}},
+		{"question", &Event[QuestionPublic]{
+			Event: EVENT_QUESTION,
+			Seq:   12,
+			Content: QuestionPublic{
+				Prompt:     "Which planet is known as the red planet?",
+				AnswerBank: []string{"Venus", "Mars", "Jupiter", "Saturn"},
+				Sleep:      20000,
+			},
+		}},
+		{"leaderboard", &Event[RevealScore]{
+			Event:   EVENT_REVEAL_SCORE,
+			Seq:     13,
+			Content: RevealScore{Scores: scores},
+		}},
+	}
+}
+
+func benchmarkEncode(b *testing.B, encoding string) {
+	for _, bench := range benchmarkEvents() {
+		b.Run(bench.name, func(b *testing.B) {
+			size := 0
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				data, _, err := newFrame(bench.event).encode(encoding)
+				if err != nil {
+					b.Fatal(err)
+				}
+				size = len(data)
+			}
+			b.ReportMetric(float64(size), "bytes/frame")
+		})
+	}
+}
+
+func BenchmarkEncodeJSON(b *testing.B) {
+	benchmarkEncode(b, ENCODING_JSON)
+}
+
+func BenchmarkEncodeCBOR(b *testing.B) {
+	benchmarkEncode(b, ENCODING_CBOR)
+}
@@ -1,5 +1,7 @@
 package ws
 
+import "fmt"
+
 // Event types
 const (
 	EVENT_ROOM_CREATED    = "event_room_created"      // room created
@@ -16,6 +18,23 @@ const (
 	EVENT_ANSWER          = "event_answer"            // player answer
 	EVENT_JOIN            = "event_player_join"       // player has joined
 	EVENT_DISCONNECT      = "event_player_disconnect" // player disconnected
+	EVENT_CONFIGURE_TEAMS = "event_configure_teams"   // host enables, disables or changes team mode
+	EVENT_ASSIGN_TEAM     = "event_assign_team"       // host moves a player into a team
+	EVENT_JOIN_TEAM       = "event_join_team"         // player picks a team
+	EVENT_TEAM_UPDATE     = "event_team_update"       // team roster has changed
+	EVENT_JOIN_REJECTED   = "event_join_rejected"     // player could not join the room
+	EVENT_KICK            = "event_kick"              // host removes a player from the room
+	EVENT_BAN             = "event_ban"               // host removes a player and prevents them from rejoining
+	EVENT_KICKED          = "event_player_kicked"     // player was removed by the host
+	EVENT_ROOM_SETTINGS   = "event_room_settings"     // host changes lobby settings such as lock and capacity
+	EVENT_SYNC            = "event_sync"              // current state of the game sent to players joining late
+	EVENT_SPECTATE        = "event_spectate"          // spectator has joined a room
+	EVENT_SPECTATORS      = "event_spectators"        // number of spectators following the room
+	EVENT_STATUS          = "event_controller_status" // compact room status sent to controllers
+	EVENT_RESTARTING      = "event_server_restarting" // server is shutting down, clients should reconnect later
+	EVENT_ACK             = "event_ack"               // client event with a request ID was handled
+	EVENT_ERROR           = "event_error"             // client event was rejected or failed
+	EVENT_REPLAY          = "event_replay"            // client asks for the room events it missed
 )
 
 // Question states
@@ -24,10 +43,28 @@ const (
 	STATE_REVEAL_LEADERBOARD = "state_reveal_leaderboard"
 )
 
+// Join rejection reasons
+const (
+	REJECT_ROOM_REQUIRED       = "room_required"
+	REJECT_ROOM_NOT_FOUND      = "room_not_found"
+	REJECT_NICKNAME_REQUIRED   = "nickname_required"
+	REJECT_NICKNAME_LENGTH     = "nickname_length"
+	REJECT_NICKNAME_CHARACTERS = "nickname_characters"
+	REJECT_NICKNAME_BLOCKED    = "nickname_blocked"
+	REJECT_NICKNAME_TAKEN      = "nickname_taken"
+	REJECT_BANNED              = "banned"
+	REJECT_ROOM_LOCKED         = "room_locked"
+	REJECT_ROOM_FULL           = "room_full"
+	REJECT_SERVER_FULL         = "server_full"
+	REJECT_GAME_IN_PROGRESS    = "game_in_progress"
+)
+
 // Event represents a WebSocket event message
 type Event[T any] struct {
-	Event   string `json:"event"`
-	Content T      `json:"content"`
+	Event     string `json:"event"`
+	RequestID string `json:"requestId,omitempty"` // optional on client events, echoed in their ack or error
+	Seq       uint64 `json:"seq,omitempty"`       // order of the events sent to a whole room, see EventLog
+	Content   T      `json:"content"`
 }
 
 // QuestionPublic represents the public question data sent to players
@@ -39,14 +76,102 @@ type QuestionPublic struct {
 
 type PlayerJoin struct {
 	PlayerId string `json:"playerId"`
+	Nickname string `json:"nickname"`
+}
+
+type JoinRejected struct {
+	Reason     string `json:"reason"`
+	Message    string `json:"message"`
+	Suggestion string `json:"suggestion,omitempty"`
 }
 type PlayerAnswer struct {
 	Answer string `json:"answer"`
 }
+
+func (a *PlayerAnswer) validate() error {
+	if len(a.Answer) == 0 {
+		return fmt.Errorf("answer is required")
+	}
+	return nil
+}
+
 type PlayerAnswerConfirmation struct {
 	ID string `json:"playerId"`
 }
 
 type PlayerDisconnect struct {
-	ID string `json:"playerId"`
+	ID     string `json:"playerId"`
+	Reason string `json:"reason"`
+}
+
+type Ack struct {
+	RequestID string `json:"requestId"`
+	Event     string `json:"event"`
+}
+
+type EventFailure struct {
+	RequestID string `json:"requestId,omitempty"`
+	Event     string `json:"event,omitempty"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+}
+
+// ReplayRequest asks for the room events after the last sequence number seen
+type ReplayRequest struct {
+	Since uint64 `json:"since"`
+}
+
+type ServerRestarting struct {
+	Message string `json:"message"`
+}
+
+type PlayerJoinTeam struct {
+	TeamID string `json:"teamId"`
+}
+
+func (j *PlayerJoinTeam) validate() error {
+	if len(j.TeamID) == 0 {
+		return fmt.Errorf("teamId is required")
+	}
+	return nil
+}
+
+type TeamMembers struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	PlayerIDs []string `json:"playerIds"`
+}
+
+type TeamUpdate struct {
+	Assign  string        `json:"assign"`
+	Scoring string        `json:"scoring"`
+	Teams   []TeamMembers `json:"teams"`
+}
+
+type PlayerKicked struct {
+	ID       string `json:"playerId"`
+	Nickname string `json:"nickname"`
+	Reason   string `json:"reason"`
+	Banned   bool   `json:"banned"`
+}
+
+// GameSync lets a client that joined mid-game catch up with the room
+type GameSync struct {
+	Phase          string          `json:"phase"`
+	QuestionNumber int             `json:"questionNumber"`
+	TotalQuestions int             `json:"totalQuestions"`
+	CanAnswer      bool            `json:"canAnswer"`
+	Question       *QuestionPublic `json:"question,omitempty"`
+	Scores         []PlayerScore   `json:"scores"`
+	Teams          []TeamScore     `json:"teams,omitempty"`
+}
+
+type SpectatorJoined struct {
+	RoomCode string   `json:"roomCode"`
+	Players  int      `json:"players"`
+	Sync     GameSync `json:"sync"`
+}
+
+type SpectatorCount struct {
+	Count int `json:"count"`
 }
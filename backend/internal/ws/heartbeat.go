@@ -0,0 +1,49 @@
+package ws
+
+import (
+	"context"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// Reasons a player disconnected
+const (
+	DISCONNECT_CLOSED  = "closed"  // the connection was closed or failed
+	DISCONNECT_TIMEOUT = "timeout" // the connection stopped answering heartbeats
+)
+
+// heartbeat pings a connection every interval until ctx is done. A ping that
+// is not answered within timeout calls onDead and closes the connection, which
+// ends the read loop of its handler. Pongs are only read while the handler is
+// reading from the connection
+func heartbeat(ctx context.Context, conn *websocket.Conn, interval, timeout time.Duration, onDead func()) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := conn.Ping(pingCtx)
+		cancel()
+		if err == nil {
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		onDead()
+		conn.CloseNow()
+		return
+	}
+}
@@ -2,130 +2,277 @@ package ws
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"math/rand"
+	"log/slog"
 	"net/http"
 	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/coder/websocket"
+	"github.com/enzofalone/kahoot/internal/auth"
+	"github.com/enzofalone/kahoot/internal/config"
+	"github.com/enzofalone/kahoot/internal/metrics"
 	"github.com/enzofalone/kahoot/internal/repo"
 )
 
-// echoServer is the WebSocket echo server implementation.
-// It ensures the client speaks the echo subprotocol and
-// only allows one message every 100ms with a 10 message burst.
+// HostHandler creates a room for every host connection and runs its game.
+// It ensures the client speaks the kahoot subprotocol and rate limits
+// the events it sends.
 type HostHandler struct {
-	logf        func(format string, args ...interface{})
-	rooms       map[string]*Room
+	logger      *slog.Logger
+	rooms       *Rooms
 	db          *repo.Database
 	broadcaster *Broadcaster
+	limits      Limits
+	codes       RoomCodeFormat
+	sessions    *auth.Sessions
+	cfg         *config.Config
+	draining    *atomic.Bool // set once the server starts shutting down
 }
 
-func NewHostHandler(logf func(format string, args ...interface{}), db *repo.Database, rooms map[string]*Room) *HostHandler {
+func NewHostHandler(logger *slog.Logger, cfg *config.Config, db *repo.Database, rooms *Rooms, broadcaster *Broadcaster, limits Limits, codes RoomCodeFormat, sessions *auth.Sessions) *HostHandler {
 	return &HostHandler{
-		logf:        logf,
+		logger:      logger,
 		rooms:       rooms,
 		db:          db,
-		broadcaster: NewBroadcaster(logf),
+		broadcaster: broadcaster,
+		limits:      limits,
+		codes:       codes,
+		sessions:    sessions,
+		cfg:         cfg,
+		draining:    &atomic.Bool{},
 	}
 }
 
-const ALL_ANSWERED_SLEEP = 3 * time.Second
-const START_GAME_SLEEP = 5 * time.Second
-const PROMPT_SLEEP = 5 * time.Second
-
 func (h HostHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.draining.Load() {
+		http.Error(w, "server is restarting", http.StatusServiceUnavailable)
+		return
+	}
+
+	// only logged in hosts, or API tokens allowed to host games, can create rooms
+	principal, err := h.sessions.Authenticate(r)
+	if errors.Is(err, auth.ErrUnauthenticated) {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to authenticate host", "err", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if !principal.Can(auth.SCOPE_HOST_GAMES) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	user := principal.User
+
+	bank := exampleBank()
+	if id := r.URL.Query().Get("bank"); len(id) > 0 {
+		bankID, err := strconv.Atoi(id)
+		if err != nil {
+			http.Error(w, "invalid bank id", http.StatusBadRequest)
+			return
+		}
+
+		bank, err = loadBank(r.Context(), h.db, bankID, user.ID)
+		if err != nil {
+			h.logger.Warn("Failed to load bank", "bank", bankID, "err", err)
+			http.Error(w, "bank not found", http.StatusNotFound)
+			return
+		}
+	}
+
 	c, err := websocket.Accept(w, r, &websocket.AcceptOptions{
-		Subprotocols:   []string{"kahoot"},
-		OriginPatterns: []string{"127.0.0.1:5173", "localhost:5173"},
+		Subprotocols:   subprotocols(SUBPROTOCOL_HOST),
+		OriginPatterns: h.cfg.Server.OriginPatterns(),
 	})
 	if err != nil {
-		h.logf("%v", err)
+		h.logger.Error("failed to accept websocket", "err", err)
 		return
 	}
 	defer c.CloseNow()
 
-	if c.Subprotocol() != "kahoot" {
+	protocol, ok := negotiate(SUBPROTOCOL_HOST, c.Subprotocol())
+	if !ok {
 		c.Close(websocket.StatusPolicyViolation, "client must speak the kahoot subprotocol")
 		return
 	}
+	h.broadcaster.Add(c, metrics.ROLE_HOST, protocol, h.logger.With("role", metrics.ROLE_HOST, "remote", r.RemoteAddr))
+	defer h.broadcaster.Remove(c)
 
-	currentRoom := h.createRoom(c)
+	currentRoom, err := h.createRoom(c, user, bank)
+	if errors.Is(err, errTooManyRooms) {
+		h.logger.Warn("Too many open rooms, refusing to create one", "err", err)
+		c.Close(websocket.StatusTryAgainLater, "Too many open rooms, try again later")
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to create room", "err", err)
+		c.Close(websocket.StatusInternalError, "Failed to create room")
+		return
+	}
+	logger := h.logger.With(LOG_ROOM, currentRoom.ID)
+	metrics.Connections.WithLabelValues(metrics.ROLE_HOST).Inc()
+	defer metrics.Connections.WithLabelValues(metrics.ROLE_HOST).Dec()
 	event := &Event[RoomCreated]{
 		Event: EVENT_ROOM_CREATED,
 		Content: RoomCreated{
-			RoomCode: currentRoom.ID,
+			RoomCode:   currentRoom.ID,
+			OwnerToken: currentRoom.OwnerToken,
 		},
 	}
-	defer h.deleteRoom(currentRoom.ID)
+	defer func() {
+		// on shutdown the host is disconnected by Shutdown, which closes the room itself
+		if !currentRoom.closing.Load() {
+			h.deleteRoom(currentRoom.ID)
+		}
+	}()
 
-	eventJson, err := json.Marshal(event)
-	if err != nil {
-		h.logf("Failed to marshal room created event: %v", err)
+	if err := h.broadcaster.SendTo(currentRoom.HostConn, event); err != nil {
+		logger.Error("Failed to send room creation event", "err", err)
 		return
 	}
 
-	if err := h.broadcaster.SendTo(currentRoom.HostConn, eventJson); err != nil {
-		h.logf("Failed to send room creation event: %v", err)
-		return
+	if err := h.sendSettings(currentRoom); err != nil {
+		logger.Error("Failed to send room settings", "err", err)
 	}
 
-	h.PrintRoomStatus()
+	h.logRoomStatus()
+
+	if h.limits.MaxMessageSize > 0 {
+		c.SetReadLimit(h.limits.MaxMessageSize)
+	}
+	limiter := newConnLimiter(h.limits.HostEvents)
+
+	alive, stopHeartbeat := context.WithCancel(context.Background())
+	defer stopHeartbeat()
+	go heartbeat(alive, c, h.limits.PingInterval, h.limits.PongTimeout, func() {
+		logger.Warn("Host stopped answering pings, closing room")
+		metrics.HeartbeatTimeouts.WithLabelValues(metrics.ROLE_HOST).Inc()
+	})
 
 	// TODO: refactor into its own function (ListenMessages)
 	for {
 		_, reader, err := c.Reader(context.Background())
 		if err != nil {
-			h.logf("Failed to get reader from host: %v", err)
+			logger.Error("Failed to get reader from host", "err", err)
 			break
 		}
 
 		message, err := io.ReadAll(reader)
 		if err != nil {
-			h.logf("Failed to read message from host: %v", err)
+			logger.Error("Failed to read message from host", "err", err)
 			break
 		}
 
-		var event Event[json.RawMessage]
-		if err := json.Unmarshal(message, &event); err != nil {
-			h.logf("Failed to unmarshal event: %v", err)
+		if allowed, disconnect := limiter.allow(); disconnect {
+			logger.Warn("Host exceeded the rate limit, disconnecting")
+			c.Close(websocket.StatusPolicyViolation, "Too many messages")
+			break
+		} else if !allowed {
+			// decoded only to tell the client which request was dropped
+			event, _ := decodeEvent(message, metrics.ROLE_HOST, protocol)
+			reply(h.broadcaster, c, event, errRateLimited)
+			continue
+		}
+
+		event, err := decodeEvent(message, metrics.ROLE_HOST, protocol)
+		if err != nil {
+			logger.Warn("Rejected event", LOG_EVENT, event.Name, "err", err)
+			reply(h.broadcaster, c, event, err)
 			continue
 		}
 
-		switch event.Event {
-		case EVENT_START:
-			if err = h.startGame(currentRoom.ID); err != nil {
-				h.logf("Failed to start game: %v", err)
+		switch event.Name {
+		case EVENT_START, EVENT_REVEAL, EVENT_NEXT, EVENT_SKIP_QUESTION:
+			err = h.control(currentRoom, event.Name)
+		case EVENT_CONFIGURE_TEAMS:
+			if err = h.configureTeams(currentRoom.ID, *event.Content.(*TeamConfig)); err != nil {
+				logger.Error("Failed to configure teams", LOG_EVENT, event.Name, "err", err)
 			}
-		case EVENT_REVEAL:
-			if err = h.showLeaderboard(currentRoom.ID); err != nil {
-				h.logf("Failed to reveal answer: %v", err)
+		case EVENT_ASSIGN_TEAM:
+			if err = h.assignTeam(currentRoom.ID, *event.Content.(*TeamAssign)); err != nil {
+				logger.Error("Failed to assign team", LOG_EVENT, event.Name, "err", err)
 			}
-		case EVENT_NEXT:
-			if currentRoom.Question.State == EVENT_REVEAL {
-				if err = h.showLeaderboard(currentRoom.ID); err != nil {
-					h.logf("Failed to show leaderboard: %v", err)
-				}
-			} else if currentRoom.Question.State == EVENT_REVEAL_SCORE {
-				if err = h.nextQuestion(currentRoom.ID); err != nil {
-					h.logf("Failed to send next question: %v", err)
-				}
+		case EVENT_ROOM_SETTINGS:
+			if err = h.updateSettings(currentRoom.ID, *event.Content.(*RoomSettings)); err != nil {
+				logger.Error("Failed to update room settings", LOG_EVENT, event.Name, "err", err)
+			}
+		case EVENT_KICK, EVENT_BAN:
+			if err = h.removePlayer(currentRoom.ID, *event.Content.(*PlayerRemoval), event.Name == EVENT_BAN); err != nil {
+				logger.Error("Failed to remove player", LOG_EVENT, event.Name, "err", err)
 			}
-		case EVENT_SKIP_QUESTION:
-			if err = h.skipQuestion(currentRoom.ID); err != nil {
-				h.logf("Failed to skip question: %v", err)
+		case EVENT_REPLAY:
+			if err = replay(h.broadcaster, c, currentRoom, metrics.ROLE_HOST, event.Content.(*ReplayRequest).Since); err != nil {
+				logger.Warn("Failed to replay events", LOG_EVENT, event.Name, "err", err)
 			}
 		}
+		reply(h.broadcaster, c, event, err)
 	}
 
-	h.PrintRoomStatus()
+	h.logRoomStatus()
 }
 
+// control handles the game flow commands shared by the host and its controllers.
+// Starting the game and moving to the next question run until the question ends,
+// so they are run in their own goroutine to keep skip available meanwhile, the
+// returned error only covers what can be checked before they are started.
+func (h HostHandler) control(room *Room, event string) error {
+	// no new questions are started while the server drains running ones
+	if h.draining.Load() && (event == EVENT_START || event == EVENT_NEXT) {
+		return eventError(ERR_RESTARTING, "server is restarting")
+	}
+
+	switch event {
+	case EVENT_START:
+		// the host and its controllers may all ask to start, only the first one does
+		if !room.beginGame() {
+			return eventError(ERR_WRONG_STATE, "game has already started")
+		}
+		go func() {
+			if err := h.startGame(room.ID); err != nil {
+				h.logger.Error("Failed to start game", LOG_ROOM, room.ID, LOG_EVENT, event, "err", err)
+			}
+		}()
+	case EVENT_REVEAL:
+		if err := h.showLeaderboard(room.ID); err != nil {
+			h.logger.Error("Failed to reveal answer", LOG_ROOM, room.ID, LOG_EVENT, event, "err", err)
+			return err
+		}
+	case EVENT_NEXT:
+		if room.Question.State == EVENT_REVEAL {
+			if err := h.showLeaderboard(room.ID); err != nil {
+				h.logger.Error("Failed to show leaderboard", LOG_ROOM, room.ID, LOG_EVENT, event, "err", err)
+				return err
+			}
+		} else if room.Question.State == EVENT_REVEAL_SCORE {
+			go func() {
+				if err := h.nextQuestion(room.ID); err != nil {
+					h.logger.Error("Failed to send next question", LOG_ROOM, room.ID, LOG_EVENT, event, "err", err)
+				}
+			}()
+		} else {
+			return eventError(ERR_WRONG_STATE, "there is no next step while the game is in %q", room.Question.State)
+		}
+	case EVENT_SKIP_QUESTION:
+		if err := h.skipQuestion(room.ID); err != nil {
+			h.logger.Error("Failed to skip question", LOG_ROOM, room.ID, LOG_EVENT, event, "err", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// startGame counts down and shows the first question of a room that left the
+// lobby through beginGame
 func (h HostHandler) startGame(roomID string) error {
-	room, exists := h.rooms[roomID]
+	room, exists := h.rooms.Get(roomID)
 	if !exists {
 		return fmt.Errorf("room %s not found", roomID)
 	}
@@ -133,28 +280,31 @@ func (h HostHandler) startGame(roomID string) error {
 	e := &Event[Start]{
 		Event: EVENT_START,
 		Content: Start{
-			Sleep:          int(START_GAME_SLEEP) * 1000,
-			TotalQuestions: len(h.rooms[roomID].Bank.Questions),
+			Sleep:          int(h.cfg.Game.StartDelay.Milliseconds()),
+			TotalQuestions: len(room.Bank.Questions),
 		},
 	}
 
-	eJson, err := json.Marshal(&e)
+	gameID, err := h.db.CreateGame(context.Background(), room.OwnerID, room.Bank.ID, room.ID)
 	if err != nil {
-		return fmt.Errorf("startGame: failed to marshal event: %v", err)
+		h.logger.Error("startGame: failed to record game", LOG_ROOM, roomID, "err", err)
 	}
+	room.GameID = gameID
+	metrics.GamesStarted.Inc()
 
-	if err := h.broadcaster.BroadcastAll(room.HostConn, room.Players, eJson); err != nil {
-		h.logf("startGame: failed to broadcast: %v", err)
+	if err := h.broadcaster.BroadcastAll(room, e); err != nil {
+		h.logger.Error("startGame: failed to broadcast", LOG_ROOM, roomID, "err", err)
 	}
+	updateControllers(h.broadcaster, room)
 
-	time.Sleep(START_GAME_SLEEP)
+	time.Sleep(h.cfg.Game.StartDelay)
 	h.nextQuestion(roomID)
 
 	return nil
 }
 
 func (h HostHandler) nextQuestion(roomID string) error {
-	room, exists := h.rooms[roomID]
+	room, exists := h.rooms.Get(roomID)
 	if !exists {
 		return fmt.Errorf("room %s not found", roomID)
 	}
@@ -173,84 +323,70 @@ func (h HostHandler) nextQuestion(roomID string) error {
 		Event: EVENT_QUESTION_PROMPT,
 		Content: Prompt{
 			Prompt: room.Bank.Questions[room.Question.Index].Prompt,
-			Sleep:  int(PROMPT_SLEEP) * 1000,
+			Sleep:  int(h.cfg.Game.PromptDelay.Milliseconds()),
 		},
 	}
 
-	promptJson, err := json.Marshal(promptEvent)
-	if err != nil {
-		h.logf("nextQuestion: failed to marshal prompt event: %v", err)
-		return err
-	}
-
-	if err := h.broadcaster.BroadcastAll(room.HostConn, room.Players, promptJson); err != nil {
-		h.logf("nextQuestion: failed to broadcast prompt: %v", err)
+	if err := h.broadcaster.BroadcastAll(room, promptEvent); err != nil {
+		h.logger.Error("nextQuestion: failed to broadcast prompt", LOG_ROOM, roomID, "err", err)
 	}
+	updateControllers(h.broadcaster, room)
 
-	time.Sleep(PROMPT_SLEEP)
+	time.Sleep(h.cfg.Game.PromptDelay)
 
 	room.Question.PostedAt = time.Now()
 	room.Question.Answers = []string{}
 	room.Question.AnswerDist = make(map[string]int)
 
 	room.Skip.Used = false // Reset skip flag for new question
-	room.Skip.Channel = make(chan struct{})
+	room.Skip.Channel = make(chan struct{}, 1)
+	room.Question.State = EVENT_QUESTION
 
 	e := &Event[QuestionPublic]{
 		Event: EVENT_QUESTION,
 		Content: QuestionPublic{
 			room.Bank.Questions[room.Question.Index].Prompt,
 			room.Bank.Questions[room.Question.Index].AnswerBank,
-			20000,
+			int(h.cfg.Game.QuestionTime.Milliseconds()),
 		},
 	}
 
-	eJson, err := json.Marshal(&e)
-	if err != nil {
-		h.logf("nextQuestion: failed to marshal event: %v", err)
-		return err
-	}
-
-	if err := h.broadcaster.BroadcastAll(room.HostConn, room.Players, eJson); err != nil {
-		h.logf("nextQuestion: failed to broadcast question: %v", err)
+	if err := h.broadcaster.BroadcastAll(room, e); err != nil {
+		h.logger.Error("nextQuestion: failed to broadcast question", LOG_ROOM, roomID, "err", err)
 	}
+	updateControllers(h.broadcaster, room)
 
-	timeout := time.After(30 * time.Second)
+	timeout := time.After(h.cfg.Game.QuestionTime)
 	ticker := time.NewTicker(100 * time.Millisecond)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			if len(room.Question.Answers) == len(room.Players) {
-				h.logf("All players answered, proceeding to reveal")
+			if len(room.Question.Answers) >= answeringPlayers(room) {
+				h.logger.Info("All players answered, proceeding to reveal", LOG_ROOM, roomID)
 
 				allAnsweredEvent := &Event[AllAnswered]{
 					Event: EVENT_ALL_ANSWERED,
 					Content: AllAnswered{
-						int(ALL_ANSWERED_SLEEP) * 1000,
+						int(h.cfg.Game.AllAnsweredDelay.Milliseconds()),
 					},
 				}
 
-				allAnsweredJson, err := json.Marshal(allAnsweredEvent)
-				if err != nil {
-					h.logf("nextQuestion: failed to marshal all answered event: %v", err)
+				if err := h.broadcaster.BroadcastAll(room, allAnsweredEvent); err != nil {
+					h.logger.Error("nextQuestion: failed to broadcast all answered event", LOG_ROOM, roomID, "err", err)
 				}
 
-				if err := h.broadcaster.BroadcastAll(room.HostConn, room.Players, allAnsweredJson); err != nil {
-					h.logf("nextQuestion: failed to broadcast all answered event: %v", err)
-				}
-
-				time.Sleep(ALL_ANSWERED_SLEEP)
+				time.Sleep(h.cfg.Game.AllAnsweredDelay)
 				h.revealAnswer(roomID)
 				return nil
 			}
 		case <-timeout:
-			h.logf("Question time limit reached, proceeding to reveal")
+			h.logger.Info("Question time limit reached, proceeding to reveal", LOG_ROOM, roomID)
 			h.revealAnswer(roomID)
 			return nil
 		case <-room.Skip.Channel:
-			h.logf("Host skipped question, proceeding to reveal")
+			h.logger.Info("Host skipped question, proceeding to reveal", LOG_ROOM, roomID)
 			h.revealAnswer(roomID)
 			return nil
 		}
@@ -258,17 +394,65 @@ func (h HostHandler) nextQuestion(roomID string) error {
 }
 
 func (h HostHandler) showLeaderboard(roomID string) error {
-	room, exists := h.rooms[roomID]
+	room, exists := h.rooms.Get(roomID)
 	if !exists {
 		return fmt.Errorf("room %s not found", roomID)
 	}
 
+	scores := topScores(room)
+
+	room.Question.State = EVENT_REVEAL_SCORE
+	e := &Event[RevealScore]{
+		Event: EVENT_REVEAL_SCORE,
+		Content: RevealScore{
+			Scores: scores,
+			Teams:  teamScores(room),
+		},
+	}
+
+	if err := h.broadcaster.SendToHost(room, e); err != nil {
+		h.logger.Error("showLeaderboard: failed to broadcast scores", LOG_ROOM, roomID, "err", err)
+	}
+	updateControllers(h.broadcaster, room)
+
+	return nil
+}
+
+// saveResults stores the final score of every player and marks the game as finished
+func (h HostHandler) saveResults(room *Room) error {
+	players := room.players()
+	results := make([]repo.Result, 0, len(players))
+	for _, p := range players {
+		results = append(results, repo.Result{
+			PlayerID: p.ID,
+			Nickname: p.Nickname,
+			Points:   p.Points,
+			TeamID:   p.TeamID,
+		})
+	}
+
+	if err := h.db.SaveResults(context.Background(), room.GameID, results); err != nil {
+		return fmt.Errorf("failed to save results: %v", err)
+	}
+
+	if err := h.db.FinishGame(context.Background(), room.GameID); err != nil {
+		return fmt.Errorf("failed to record game end: %v", err)
+	}
+
+	return nil
+}
+
+// topScores returns the 10 best players of the room sorted by points
+func topScores(room *Room) []PlayerScore {
 	// Sort players by points in descending order
-	scores := make([]PlayerScore, 0, len(room.Players))
-	for _, p := range room.Players {
+	players := room.players()
+	scores := make([]PlayerScore, 0, len(players))
+	for _, p := range players {
 		scores = append(scores, PlayerScore{
-			ID:     p.ID,
-			Points: p.Points,
+			ID:       p.ID,
+			Nickname: p.Nickname,
+			Points:   p.Points,
+			TeamID:   p.TeamID,
 		})
 	}
 
@@ -282,28 +466,11 @@ func (h HostHandler) showLeaderboard(roomID string) error {
 		scores = scores[:10]
 	}
 
-	room.Question.State = EVENT_REVEAL_SCORE
-	e := &Event[RevealScore]{
-		Event: EVENT_REVEAL_SCORE,
-		Content: RevealScore{
-			Scores: scores,
-		},
-	}
-
-	eJson, err := json.Marshal(&e)
-	if err != nil {
-		h.logf("showLeaderboard: failed to marshal event: %v", err)
-	}
-
-	if err := h.broadcaster.SendTo(room.HostConn, eJson); err != nil {
-		h.logf("showLeaderboard: failed to broadcast scores: %v", err)
-	}
-
-	return nil
+	return scores
 }
 
 func (h HostHandler) revealAnswer(roomID string) error {
-	room, exists := h.rooms[roomID]
+	room, exists := h.rooms.Get(roomID)
 	if !exists {
 		return fmt.Errorf("room %s not found", roomID)
 	}
@@ -317,14 +484,10 @@ func (h HostHandler) revealAnswer(roomID string) error {
 		},
 	}
 
-	eJson, err := json.Marshal(&e)
-	if err != nil {
-		return fmt.Errorf("revealAnswer: failed to marshal event: %v", err)
-	}
-
-	if err := h.broadcaster.BroadcastAll(room.HostConn, room.Players, eJson); err != nil {
-		h.logf("revealAnswer: failed to broadcast answer: %v", err)
+	if err := h.broadcaster.BroadcastAll(room, e); err != nil {
+		h.logger.Error("revealAnswer: failed to broadcast answer", LOG_ROOM, roomID, "err", err)
 	}
+	updateControllers(h.broadcaster, room)
 
 	// TODO: send to every player their score received from the answer
 
@@ -332,49 +495,35 @@ func (h HostHandler) revealAnswer(roomID string) error {
 }
 
 func (h HostHandler) revealResults(roomID string) error {
-	room, exists := h.rooms[roomID]
+	room, exists := h.rooms.Get(roomID)
 	if !exists {
 		return fmt.Errorf("room %s not found", roomID)
 	}
 	room.Question.State = EVENT_FINISH
+	scores := topScores(room)
+	metrics.GamesFinished.Inc()
 
-	// Sort players by points in descending order
-	scores := make([]PlayerScore, 0, len(room.Players))
-	for _, p := range room.Players {
-		scores = append(scores, PlayerScore{
-			ID:     p.ID,
-			Points: p.Points,
-		})
-	}
-
-	// Sort scores slice by points descending
-	sort.Slice(scores, func(i, j int) bool {
-		return scores[i].Points > scores[j].Points
-	})
-
-	// Get top 10 scores
-	if len(scores) > 10 {
-		scores = scores[:10]
+	if room.GameID > 0 {
+		if err := h.saveResults(room); err != nil {
+			h.logger.Error("revealResults: failed to record game", LOG_ROOM, roomID, "err", err)
+		}
 	}
 
 	e := &Event[Finish]{
 		Event: EVENT_FINISH,
 		Content: Finish{
 			Scores: scores,
-			Sleep:  30000,
+			Teams:  teamScores(room),
+			Sleep:  int(h.cfg.Game.FinishDelay.Milliseconds()),
 		},
 	}
 
-	eJson, err := json.Marshal(&e)
-	if err != nil {
-		return fmt.Errorf("revealResults: failed to marshal event: %v", err)
-	}
-
-	if err := h.broadcaster.BroadcastAll(room.HostConn, room.Players, eJson); err != nil {
-		h.logf("revealResults: failed to broadcast results: %v", err)
+	if err := h.broadcaster.BroadcastAll(room, e); err != nil {
+		h.logger.Error("revealResults: failed to broadcast results", LOG_ROOM, roomID, "err", err)
 	}
+	updateControllers(h.broadcaster, room)
 
-	time.Sleep(30 * time.Second)
+	time.Sleep(h.cfg.Game.FinishDelay)
 	h.deleteRoom(roomID)
 
 	return nil
@@ -382,54 +531,61 @@ func (h HostHandler) revealResults(roomID string) error {
 
 // deleteRoom removes a room and disconnects all players within
 func (h HostHandler) deleteRoom(roomID string) error {
-	room, exists := h.rooms[roomID]
+	return h.closeRoom(roomID, websocket.StatusNormalClosure, "Room has been closed")
+}
+
+// closeRoom removes a room, closing the connection of its players, spectators
+// and controllers with the given status
+func (h HostHandler) closeRoom(roomID string, status websocket.StatusCode, reason string) error {
+	// only the caller that removes the room closes it
+	room, exists := h.rooms.Remove(roomID)
 	if !exists {
 		return fmt.Errorf("room %s not found", roomID)
 	}
 
-	// Disconnect all players in the room
-	for _, player := range room.Players {
-		player.Conn.Close(websocket.StatusNormalClosure, "Room has been closed")
+	// Disconnect all players and spectators in the room, closing waits for
+	// every client to acknowledge so they are closed concurrently
+	players := room.players()
+	spectators := room.spectators()
+	controllers := room.controllers()
+	conns := make([]*websocket.Conn, 0, len(players)+len(spectators)+len(controllers))
+	for _, player := range players {
+		conns = append(conns, player.Conn)
+	}
+	for _, spectator := range spectators {
+		conns = append(conns, spectator.Conn)
+	}
+	for _, controller := range controllers {
+		conns = append(conns, controller.Conn)
 	}
 
-	// Remove the room from the map
-	delete(h.rooms, roomID)
+	var wg sync.WaitGroup
+	for _, c := range conns {
+		wg.Add(1)
+		go func(c *websocket.Conn) {
+			defer wg.Done()
+			h.broadcaster.Close(c, status, reason)
+		}(c)
+	}
+	wg.Wait()
+
+	metrics.RoomsActive.Dec()
 
-	h.logf("Room %s has been deleted", roomID)
+	h.logger.Info("Room deleted", LOG_ROOM, roomID)
 	return nil
 }
 
 // creates a room assigned to a host
-func (h HostHandler) createRoom(c *websocket.Conn) *Room {
-	questions := []Question{
-		{
-			Prompt:        "What is 2 + 2?",
-			AnswerBank:    []string{"3", "4", "5", "6"},
-			CorrectAnswer: "4",
-		},
-		{
-			Prompt:        "Which planet is closest to the Sun?",
-			AnswerBank:    []string{"Venus", "Mars", "Mercury", "Earth"},
-			CorrectAnswer: "Mercury",
-		},
-		{
-			Prompt:        "What color is a banana?",
-			AnswerBank:    []string{"Red", "Green", "Yellow", "Blue"},
-			CorrectAnswer: "Yellow",
-		},
-	}
-
-	b := &Bank{
-		ID:        0,
-		Title:     "Example Bank",
-		Questions: questions,
-	}
-
+func (h HostHandler) createRoom(c *websocket.Conn, owner *repo.User, bank *Bank) (*Room, error) {
 	r := &Room{
-		ID:       h.generateRoomID(),
-		Players:  []*Player{},
-		HostConn: c,
-		Bank:     b,
+		OwnerID:    owner.ID,
+		OwnerToken: generateToken(),
+		Players:    []*Player{},
+		MaxPlayers: h.limits.MaxPlayersPerRoom,
+		LateJoin:   LATE_JOIN_DISALLOW,
+		HostConn:   c,
+		Bank:       bank,
+		Events:     newEventLog(h.limits.ReplayBuffer),
 		Question: QuestionState{
 			AnswerDist: make(map[string]int),
 		},
@@ -438,58 +594,96 @@ func (h HostHandler) createRoom(c *websocket.Conn) *Room {
 		},
 	}
 
-	h.rooms[r.ID] = r
-	h.logf("Room %s created", r.ID)
-	return r
-}
+	// another host may take the code between generating and adding it
+	for {
+		roomID, err := h.generateRoomID()
+		if err != nil {
+			return nil, fmt.Errorf("createRoom: failed to generate room code: %v", err)
+		}
 
-func (h HostHandler) generateRoomID() string {
-	const charset = "abcdefghijklmnopqrstuvwxyz0123456789"
-	const length = 6
+		r.ID = roomID
+		err = h.rooms.Add(r)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, errCodeTaken) {
+			return nil, fmt.Errorf("createRoom: %w", err)
+		}
+	}
+	metrics.RoomsActive.Inc()
+	h.logger.Info("Room created", LOG_ROOM, r.ID, "owner", owner.ID, "bank", bank.ID)
+	return r, nil
+}
 
+func (h HostHandler) generateRoomID() (string, error) {
 	for {
-		b := make([]byte, length)
-		for i := range b {
-			b[i] = charset[rand.Intn(len(charset))]
+		roomID, err := h.codes.Generate()
+		if err != nil {
+			return "", err
 		}
-		roomID := string(b)
 
-		if _, exists := h.rooms[roomID]; !exists {
-			return roomID
+		if _, exists := h.rooms.Get(roomID); !exists {
+			return roomID, nil
 		}
 	}
 }
 
-func (h HostHandler) PrintRoomStatus() {
-	fmt.Println("\n=== Room Status ===")
-	for roomID, room := range h.rooms {
-		fmt.Printf("\nRoom: %s\n", roomID)
-		fmt.Printf("Players (%d):\n", len(room.Players))
-		for _, player := range room.Players {
-			fmt.Printf("- %s\n", player.ID)
-		}
+// configureTeams changes the team mode of a room, only allowed while in the lobby
+func (h HostHandler) configureTeams(roomID string, config TeamConfig) error {
+	room, exists := h.rooms.Get(roomID)
+	if !exists {
+		return fmt.Errorf("room %s not found", roomID)
+	}
+
+	if err := configureTeams(room, config); err != nil {
+		return err
+	}
+
+	return broadcastTeams(h.broadcaster, room)
+}
+
+func (h HostHandler) assignTeam(roomID string, assign TeamAssign) error {
+	room, exists := h.rooms.Get(roomID)
+	if !exists {
+		return fmt.Errorf("room %s not found", roomID)
+	}
+
+	room.mu.Lock()
+	err := assignTeam(room, assign.PlayerID, assign.TeamID)
+	room.mu.Unlock()
+	if err != nil {
+		return err
 	}
-	fmt.Println("===========")
+
+	return broadcastTeams(h.broadcaster, room)
 }
 
 func (h HostHandler) skipQuestion(roomID string) error {
-	room, exists := h.rooms[roomID]
+	room, exists := h.rooms.Get(roomID)
 	if !exists {
 		return fmt.Errorf("room %s not found", roomID)
 	}
 
+	if room.Question.State != EVENT_QUESTION {
+		return eventError(ERR_WRONG_STATE, "no question is running in room %s", roomID)
+	}
+
 	// Check if skip has already been used for this question
 	if room.Skip.Used {
-		return fmt.Errorf("skip already used for this question")
+		return eventError(ERR_SKIP_USED, "skip already used for this question")
 	}
 
 	// Signal the skip channel if it exists
 	if room.Skip.Channel != nil {
 		room.Skip.Used = true
-		room.Skip.Channel <- struct{}{}
+		select {
+		case room.Skip.Channel <- struct{}{}:
+		default:
+		}
+		updateControllers(h.broadcaster, room)
 		return nil
 	}
 
-	h.logf("Warning: skip channel not initialized, revealing answer directly")
+	h.logger.Warn("Skip channel not initialized, revealing answer directly", LOG_ROOM, roomID)
 	return h.revealAnswer(roomID)
 }
@@ -1,7 +1,10 @@
 package ws
 
+import "fmt"
+
 type RoomCreated struct {
-	RoomCode string `json:"roomCode"`
+	RoomCode   string `json:"roomCode"`
+	OwnerToken string `json:"ownerToken"` // lets controllers such as a phone drive the game
 }
 
 type Start struct {
@@ -20,6 +23,7 @@ type AllAnswered struct {
 
 type RevealScore struct {
 	Scores []PlayerScore `json:"scores"`
+	Teams  []TeamScore   `json:"teams,omitempty"`
 }
 
 type Reveal struct {
@@ -29,5 +33,54 @@ type Reveal struct {
 
 type Finish struct {
 	Scores []PlayerScore `json:"scores"`
+	Teams  []TeamScore   `json:"teams,omitempty"`
 	Sleep  int           `json:"sleep"`
 }
+
+type TeamConfig struct {
+	Enabled bool     `json:"enabled"`
+	Assign  string   `json:"assign"`  // manual or auto
+	Scoring string   `json:"scoring"` // sum or average
+	Teams   []string `json:"teams"`   // team names
+}
+
+type TeamAssign struct {
+	PlayerID string `json:"playerId"`
+	TeamID   string `json:"teamId"`
+}
+
+func (a *TeamAssign) validate() error {
+	if len(a.PlayerID) == 0 || len(a.TeamID) == 0 {
+		return fmt.Errorf("playerId and teamId are required")
+	}
+	return nil
+}
+
+type PlayerRemoval struct {
+	PlayerID string `json:"playerId"`
+	Reason   string `json:"reason"`
+}
+
+func (r *PlayerRemoval) validate() error {
+	if len(r.PlayerID) == 0 {
+		return fmt.Errorf("playerId is required")
+	}
+	return nil
+}
+
+// RoomSettings only changes the fields that are present
+type RoomSettings struct {
+	Locked     *bool   `json:"locked,omitempty"`
+	MaxPlayers *int    `json:"maxPlayers,omitempty"`
+	LateJoin   *string `json:"lateJoin,omitempty"` // disallow, next_question or immediate
+}
+
+// ControllerStatus is the compact view of a room sent to controllers
+type ControllerStatus struct {
+	Phase          string `json:"phase"`
+	QuestionNumber int    `json:"questionNumber"`
+	TotalQuestions int    `json:"totalQuestions"`
+	Players        int    `json:"players"`
+	Answered       int    `json:"answered"`
+	CanSkip        bool   `json:"canSkip"`
+}
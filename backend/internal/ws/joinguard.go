@@ -0,0 +1,213 @@
+package ws
+
+import (
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// JoinGuardConfig configures how failed attempts to join unknown rooms are throttled
+type JoinGuardConfig struct {
+	FreeAttempts    int           // failures allowed per IP before backoff kicks in
+	BaseDelay       time.Duration // first backoff, doubled on every further failure
+	MaxDelay        time.Duration
+	Forget          time.Duration // failures older than this are forgotten
+	SuspiciousAfter int           // failures from one IP before it is logged as suspicious
+	MaxPending      int           // attempts from one IP looking up a room at once, 0 means unlimited
+
+	// the circuit breaker rejects every join when the whole server sees too many failures
+	BreakerThreshold int // failures within BreakerWindow that open the breaker, 0 disables it
+	BreakerWindow    time.Duration
+	BreakerCooldown  time.Duration
+}
+
+type failedJoins struct {
+	count        int
+	pending      int // allowed attempts that did not find out yet whether the room exists
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// JoinGuard throttles clients that repeatedly try to join rooms that do not exist,
+// making room codes impractical to brute-force. Only attempts that name an
+// unknown room count as failures, and joining a known room does not wipe the
+// failures that came before. Attempts still looking up their room are limited
+// per IP, so a burst of concurrent guesses cannot slip past the backoff while
+// a classroom behind one address can still join at once
+type JoinGuard struct {
+	logger *slog.Logger
+	config JoinGuardConfig
+
+	mu        sync.Mutex
+	attempts  map[string]*failedJoins // keyed by client IP
+	lastSweep time.Time
+
+	windowStart    time.Time
+	windowFailures int
+	openUntil      time.Time
+}
+
+func NewJoinGuard(logger *slog.Logger, config JoinGuardConfig) *JoinGuard {
+	return &JoinGuard{
+		logger:   logger,
+		config:   config,
+		attempts: make(map[string]*failedJoins),
+	}
+}
+
+// Allow reports whether a client may attempt to join. An allowed attempt is
+// pending until it is ended with Fail or Release. When it is not allowed, the
+// HTTP status and retry delay to answer with are returned
+func (g *JoinGuard) Allow(ip string) (bool, int, time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(g.openUntil) {
+		return false, http.StatusServiceUnavailable, g.openUntil.Sub(now)
+	}
+
+	g.sweep(now)
+	f := g.client(ip, now)
+	if now.Before(f.blockedUntil) {
+		return false, http.StatusTooManyRequests, f.blockedUntil.Sub(now)
+	}
+
+	if g.config.MaxPending > 0 && f.pending >= g.config.MaxPending {
+		return false, http.StatusTooManyRequests, time.Second
+	}
+
+	f.pending++
+	return true, 0, 0
+}
+
+// client returns the record of an IP, starting a new one when its failures
+// were forgotten. The caller holds g.mu
+func (g *JoinGuard) client(ip string, now time.Time) *failedJoins {
+	f, exists := g.attempts[ip]
+	if !exists {
+		f = &failedJoins{}
+		g.attempts[ip] = f
+	} else if f.count > 0 && now.Sub(f.lastFailure) > g.config.Forget {
+		f.count = 0
+	}
+	return f
+}
+
+// Release ends an allowed attempt that did not name an unknown room
+func (g *JoinGuard) Release(ip string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if f, exists := g.attempts[ip]; exists && f.pending > 0 {
+		f.pending--
+	}
+}
+
+// Fail ends an allowed attempt that named an unknown room, backing the IP off
+// once it used its free attempts
+func (g *JoinGuard) Fail(ip string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	f := g.client(ip, now)
+	if f.pending > 0 {
+		f.pending--
+	}
+	f.count++
+	f.lastFailure = now
+
+	if over := f.count - g.config.FreeAttempts; over > 0 {
+		delay := time.Duration(float64(g.config.BaseDelay) * math.Pow(2, float64(over-1)))
+		if delay > g.config.MaxDelay || delay <= 0 {
+			delay = g.config.MaxDelay
+		}
+		f.blockedUntil = now.Add(delay)
+	}
+
+	if g.config.SuspiciousAfter > 0 && f.count%g.config.SuspiciousAfter == 0 {
+		g.logger.Warn("Suspicious join attempts", "ip", ip, "failures", f.count)
+	}
+
+	if g.config.BreakerThreshold <= 0 {
+		return
+	}
+
+	if now.Sub(g.windowStart) > g.config.BreakerWindow {
+		g.windowStart = now
+		g.windowFailures = 0
+	}
+	g.windowFailures++
+
+	if g.windowFailures >= g.config.BreakerThreshold && !now.Before(g.openUntil) {
+		g.openUntil = now.Add(g.config.BreakerCooldown)
+		g.windowFailures = 0
+		g.logger.Warn("Too many failed joins across the server, rejecting joins", "cooldown", g.config.BreakerCooldown)
+	}
+}
+
+// sweep drops forgotten entries, at most once per minute
+func (g *JoinGuard) sweep(now time.Time) {
+	if now.Sub(g.lastSweep) < time.Minute {
+		return
+	}
+	g.lastSweep = now
+
+	for ip, f := range g.attempts {
+		if f.pending == 0 && now.Sub(f.lastFailure) > g.config.Forget && now.After(f.blockedUntil) {
+			delete(g.attempts, ip)
+		}
+	}
+}
+
+// joinAttempt is an allowed attempt to join a room, ended once whichever of
+// fail and release is called first
+type joinAttempt struct {
+	guard *JoinGuard
+	ip    string
+	ended bool
+}
+
+// fail ends the attempt as naming an unknown room
+func (a *joinAttempt) fail() {
+	if !a.ended {
+		a.ended = true
+		a.guard.Fail(a.ip)
+	}
+}
+
+// release ends the attempt without counting a failure, callers defer it so
+// attempts that stop early do not stay pending
+func (a *joinAttempt) release() {
+	if !a.ended {
+		a.ended = true
+		a.guard.Release(a.ip)
+	}
+}
+
+// guardJoin rejects the request before the websocket upgrade if the client is throttled
+func guardJoin(g *JoinGuard, w http.ResponseWriter, r *http.Request) (*joinAttempt, bool) {
+	ip := clientIP(r)
+
+	allowed, status, retryAfter := g.Allow(ip)
+	if !allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		http.Error(w, http.StatusText(status), status)
+		return nil, false
+	}
+
+	return &joinAttempt{guard: g, ip: ip}, true
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
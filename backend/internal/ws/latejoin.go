@@ -0,0 +1,100 @@
+package ws
+
+import (
+	"time"
+)
+
+// Late join policies
+const (
+	LATE_JOIN_DISALLOW      = "disallow"      // players cannot join once the game has started
+	LATE_JOIN_NEXT_QUESTION = "next_question" // players join but can only answer from the next question on
+	LATE_JOIN_IMMEDIATE     = "immediate"     // players join and can answer the current question
+)
+
+func validLateJoin(policy string) bool {
+	return policy == LATE_JOIN_DISALLOW || policy == LATE_JOIN_NEXT_QUESTION || policy == LATE_JOIN_IMMEDIATE
+}
+
+// gameStarted reports whether the room has left the lobby
+func gameStarted(room *Room) bool {
+	return room.Question.State != ""
+}
+
+// beginGame moves a room out of the lobby, reporting false when the game
+// has already started. The check and the change are done under the room's
+// lock so that a game is started once
+func (room *Room) beginGame() bool {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if gameStarted(room) {
+		return false
+	}
+	room.Question.State = EVENT_START
+	return true
+}
+
+// canAnswer reports whether a player is allowed to answer the current question
+func canAnswer(room *Room, player *Player) bool {
+	return room.Question.Index >= player.AnswersFrom
+}
+
+// answeringPlayers counts the players expected to answer the current question,
+// players whose connection went dead are not waited for
+func answeringPlayers(room *Room) int {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	count := 0
+	for _, p := range room.Players {
+		if canAnswer(room, p) && !p.Disconnected.Load() {
+			count++
+		}
+	}
+	return count
+}
+
+// prepareLateJoin sets from which question a player joining mid-game may answer
+func prepareLateJoin(room *Room, player *Player) {
+	if !gameStarted(room) {
+		return
+	}
+
+	player.AnswersFrom = room.Question.Index
+	if room.LateJoin == LATE_JOIN_NEXT_QUESTION {
+		player.AnswersFrom = room.Question.Index + 1
+	}
+}
+
+// syncLateJoin sends a player that joined mid-game what the room is currently showing
+func (p PlayerHandler) syncLateJoin(room *Room, player *Player) error {
+	if !gameStarted(room) {
+		return nil
+	}
+
+	sync := GameSync{
+		Phase:          room.Question.State,
+		QuestionNumber: room.Question.Index,
+		TotalQuestions: len(room.Bank.Questions),
+		CanAnswer:      canAnswer(room, player),
+		Scores:         topScores(room),
+		Teams:          teamScores(room),
+	}
+
+	if room.Question.State == EVENT_QUESTION {
+		question := room.Bank.Questions[room.Question.Index]
+		remaining := p.cfg.Game.QuestionTime - time.Since(room.Question.PostedAt)
+		sync.Question = &QuestionPublic{
+			Prompt:     question.Prompt,
+			AnswerBank: question.AnswerBank,
+			Sleep:      int(remaining.Milliseconds()),
+		}
+	}
+
+	e := &Event[GameSync]{
+		Event:   EVENT_SYNC,
+		Content: sync,
+	}
+
+	return p.broadcaster.SendTo(player.Conn, e)
+}
@@ -0,0 +1,122 @@
+package ws
+
+import (
+	"fmt"
+	"time"
+)
+
+// Limits caps how many players can be connected and how much they can send, zero means unlimited
+type Limits struct {
+	MaxPlayersPerRoom int // default and upper bound of a room's capacity
+	MaxPlayers        int // across every room of the server
+
+	MaxSpectatorsPerRoom int
+
+	MaxMessageSize int64 // in bytes, larger messages close the connection
+	PlayerEvents   RateLimit
+	HostEvents     RateLimit // applies to hosts and controllers
+
+	SendQueue    int           // outbound messages buffered per connection
+	WriteTimeout time.Duration // for a single message to be written
+	PingInterval time.Duration // between heartbeats, 0 disables them
+	PongTimeout  time.Duration // before a connection missing a pong is dropped
+	ReplayBuffer int           // events kept per room for clients to catch up
+}
+
+// JoinError describes why a player could not join a room
+type JoinError struct {
+	Reason     string
+	Message    string
+	Suggestion string // alternative nickname the player may use, if any
+}
+
+func (e *JoinError) Error() string {
+	return e.Message
+}
+
+// serverFull reports whether the server reached its limit of players across every room
+func serverFull(rooms *Rooms, limits Limits) bool {
+	return limits.MaxPlayers > 0 && rooms.PlayerCount() >= limits.MaxPlayers
+}
+
+// Full reports whether players can no longer join any room of the server
+func (p PlayerHandler) Full() bool {
+	return serverFull(p.rooms, p.limits)
+}
+
+// canJoin checks lobby lock, game state and capacity of a room before a player
+// joins, full tells whether the server is full. The caller holds the room's lock
+func canJoin(room *Room, full bool) *JoinError {
+	if room.Locked {
+		return &JoinError{Reason: REJECT_ROOM_LOCKED, Message: "Room is locked"}
+	}
+
+	if gameStarted(room) && room.LateJoin == LATE_JOIN_DISALLOW {
+		return &JoinError{Reason: REJECT_GAME_IN_PROGRESS, Message: "Game in progress"}
+	}
+
+	if room.MaxPlayers > 0 && len(room.Players) >= room.MaxPlayers {
+		return &JoinError{Reason: REJECT_ROOM_FULL, Message: "Room is full"}
+	}
+
+	if full {
+		return &JoinError{Reason: REJECT_SERVER_FULL, Message: "Server is full, try again later"}
+	}
+
+	return nil
+}
+
+// updateSettings applies the host's lobby settings to a room. The whole
+// payload is validated before anything changes, so an invalid setting leaves
+// the room as it was
+func (h HostHandler) updateSettings(roomID string, settings RoomSettings) error {
+	room, exists := h.rooms.Get(roomID)
+	if !exists {
+		return fmt.Errorf("room %s not found", roomID)
+	}
+
+	if settings.MaxPlayers != nil && *settings.MaxPlayers < 0 {
+		return eventError(ERR_INVALID, "max players cannot be negative")
+	}
+	if settings.LateJoin != nil && !validLateJoin(*settings.LateJoin) {
+		return eventError(ERR_INVALID, "unknown late join policy %q", *settings.LateJoin)
+	}
+
+	// applied under the room's lock so joining players see all of the settings or none
+	room.mu.Lock()
+	if settings.Locked != nil {
+		room.Locked = *settings.Locked
+	}
+	if settings.MaxPlayers != nil {
+		max := *settings.MaxPlayers
+		// rooms cannot go above the server limit, 0 falls back to it
+		if h.limits.MaxPlayersPerRoom > 0 && (max == 0 || max > h.limits.MaxPlayersPerRoom) {
+			max = h.limits.MaxPlayersPerRoom
+		}
+		room.MaxPlayers = max
+	}
+	if settings.LateJoin != nil {
+		room.LateJoin = *settings.LateJoin
+	}
+	room.mu.Unlock()
+
+	return h.sendSettings(room)
+}
+
+// sendSettings sends the current lobby settings of a room to its host
+func (h HostHandler) sendSettings(room *Room) error {
+	locked := room.Locked
+	maxPlayers := room.MaxPlayers
+	lateJoin := room.LateJoin
+
+	e := &Event[RoomSettings]{
+		Event: EVENT_ROOM_SETTINGS,
+		Content: RoomSettings{
+			Locked:     &locked,
+			MaxPlayers: &maxPlayers,
+			LateJoin:   &lateJoin,
+		},
+	}
+
+	return h.broadcaster.SendTo(room.HostConn, e)
+}
@@ -0,0 +1,41 @@
+package ws
+
+import (
+	"context"
+	"log/slog"
+)
+
+// attribute keys attached to log lines so they can be filtered by room, player and event
+const (
+	LOG_ROOM   = "room"
+	LOG_PLAYER = "player"
+	LOG_EVENT  = "event"
+)
+
+// logRoomStatus writes the players of every room at debug level
+func (h HostHandler) logRoomStatus() {
+	if !h.logger.Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+
+	for _, room := range h.rooms.All() {
+		players := room.players()
+		scores := make([]PlayerScore, 0, len(players))
+		for _, p := range players {
+			scores = append(scores, PlayerScore{
+				ID:       p.ID,
+				Nickname: p.Nickname,
+				Points:   p.Points,
+				TeamID:   p.TeamID,
+			})
+		}
+
+		h.logger.Debug("room status",
+			LOG_ROOM, room.ID,
+			"state", room.Question.State,
+			"spectators", len(room.spectators()),
+			"controllers", len(room.controllers()),
+			"players", scores,
+		)
+	}
+}
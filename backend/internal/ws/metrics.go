@@ -0,0 +1,25 @@
+package ws
+
+import "github.com/enzofalone/kahoot/internal/metrics"
+
+// eventLabel keeps the event label bounded to the events of the registry,
+// anything else a client sends is counted as unknown
+func eventLabel(event string) string {
+	if _, known := registry[event]; known {
+		return event
+	}
+	return "unknown"
+}
+
+// countReceived records an event read from a client
+func countReceived(event string) {
+	metrics.Messages.WithLabelValues(metrics.DIRECTION_IN, eventLabel(event)).Inc()
+}
+
+// countSent records an event written to n connections
+func countSent(event string, n int) {
+	if n == 0 {
+		return
+	}
+	metrics.Messages.WithLabelValues(metrics.DIRECTION_OUT, eventLabel(event)).Add(float64(n))
+}
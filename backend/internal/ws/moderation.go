@@ -0,0 +1,90 @@
+package ws
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/coder/websocket"
+)
+
+// BanList keeps track of players banned from a room for the rest of its life
+type BanList struct {
+	Nicknames []string // lowercased nicknames
+	Sessions  []string // client session IDs
+}
+
+// isBanned reports whether a joining client matches a ban by nickname or session
+func (b *BanList) isBanned(nickname string, sessionID string) bool {
+	if slices.Contains(b.Nicknames, strings.ToLower(nickname)) {
+		return true
+	}
+	return len(sessionID) > 0 && slices.Contains(b.Sessions, sessionID)
+}
+
+func (b *BanList) add(player *Player) {
+	b.Nicknames = append(b.Nicknames, strings.ToLower(player.Nickname))
+	if len(player.SessionID) > 0 {
+		b.Sessions = append(b.Sessions, player.SessionID)
+	}
+}
+
+// removePlayer kicks a player out of the room, optionally banning them, and
+// notifies both the player and the host
+func (h HostHandler) removePlayer(roomID string, request PlayerRemoval, ban bool) error {
+	room, exists := h.rooms.Get(roomID)
+	if !exists {
+		return fmt.Errorf("room %s not found", roomID)
+	}
+
+	room.mu.Lock()
+	i := slices.IndexFunc(room.Players, func(p *Player) bool {
+		return p.ID == request.PlayerID
+	})
+	if i == -1 {
+		room.mu.Unlock()
+		return eventError(ERR_NOT_FOUND, "player %s not found in room %s", request.PlayerID, roomID)
+	}
+	player := room.Players[i]
+	keepTeamPoints(room, player)
+	room.Players = slices.Delete(room.Players, i, i+1)
+	if ban {
+		room.Bans.add(player)
+	}
+	room.mu.Unlock()
+
+	reason := request.Reason
+	if len(reason) == 0 {
+		reason = "Removed by host"
+	}
+
+	kicked := &Event[PlayerKicked]{
+		Event: EVENT_KICKED,
+		Content: PlayerKicked{
+			ID:       player.ID,
+			Nickname: player.Nickname,
+			Reason:   reason,
+			Banned:   ban,
+		},
+	}
+
+	if err := h.broadcaster.SendTo(player.Conn, kicked); err != nil {
+		h.logger.Error("removePlayer: failed to notify player", LOG_ROOM, roomID, LOG_PLAYER, player.ID, "err", err)
+	}
+	h.broadcaster.Close(player.Conn, websocket.StatusPolicyViolation, reason)
+
+	if err := h.broadcaster.SendToHost(room, kicked); err != nil {
+		h.logger.Error("removePlayer: failed to notify host", LOG_ROOM, roomID, "err", err)
+	}
+
+	updateControllers(h.broadcaster, room)
+
+	if teamsEnabled(room) {
+		if err := broadcastTeams(h.broadcaster, room); err != nil {
+			h.logger.Error("removePlayer: failed to send team roster", LOG_ROOM, roomID, "err", err)
+		}
+	}
+
+	h.logger.Info("Player removed", LOG_ROOM, roomID, LOG_PLAYER, player.ID, "nickname", player.Nickname, "banned", ban)
+	return nil
+}
@@ -0,0 +1,182 @@
+package ws
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// NicknamePolicy defines which display names players are allowed to use
+type NicknamePolicy struct {
+	MinLength    int
+	MaxLength    int
+	BlockedWords []string // compared against whole words of the leetspeak-normalized nickname
+}
+
+// leetspeak substitutions applied before matching blocked words
+var leetReplacer = strings.NewReplacer(
+	"0", "o",
+	"1", "i",
+	"!", "i",
+	"|", "i",
+	"3", "e",
+	"4", "a",
+	"@", "a",
+	"5", "s",
+	"$", "s",
+	"7", "t",
+	"+", "t",
+	"8", "b",
+	"9", "g",
+)
+
+// LoadBlockedWords reads one blocked word per line from a file,
+// empty lines and lines starting with # are ignored
+func LoadBlockedWords(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	words := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		word := strings.TrimSpace(scanner.Text())
+		if len(word) == 0 || strings.HasPrefix(word, "#") {
+			continue
+		}
+		words = append(words, word)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return words, nil
+}
+
+// Normalize trims the nickname and collapses repeated whitespace
+func (n *NicknamePolicy) Normalize(nickname string) string {
+	return strings.Join(strings.Fields(nickname), " ")
+}
+
+// Validate checks length, characters and blocked words of an already normalized nickname
+func (n *NicknamePolicy) Validate(nickname string) error {
+	if len(nickname) == 0 {
+		return &JoinError{
+			Reason:  REJECT_NICKNAME_REQUIRED,
+			Message: "Nickname is required",
+		}
+	}
+
+	length := utf8.RuneCountInString(nickname)
+	if length < n.MinLength || length > n.MaxLength {
+		return &JoinError{
+			Reason:  REJECT_NICKNAME_LENGTH,
+			Message: fmt.Sprintf("Nickname must be between %d and %d characters", n.MinLength, n.MaxLength),
+		}
+	}
+
+	for _, r := range nickname {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune(" _-.", r) {
+			continue
+		}
+		return &JoinError{
+			Reason:  REJECT_NICKNAME_CHARACTERS,
+			Message: "Nickname may only contain letters, numbers, spaces, '_', '-' and '.'",
+		}
+	}
+
+	if n.isBlocked(nickname) {
+		return &JoinError{
+			Reason:  REJECT_NICKNAME_BLOCKED,
+			Message: "Nickname is not allowed",
+		}
+	}
+
+	return nil
+}
+
+// isBlocked reports whether the nickname spells a blocked word. The nickname
+// is split into words on spaces, '_', '-' and '.', and each word has casing
+// and leetspeak normalized away. A blocked word matches when it equals one
+// word, optionally followed by digits ("name99"), or a run of consecutive
+// words joined together ("b a d", "bad_word"). Blocked words inside a longer
+// word do not match, so "Scunthorpe" or "classic" are allowed
+func (n *NicknamePolicy) isBlocked(nickname string) bool {
+	blocked := make(map[string]bool, len(n.BlockedWords))
+	for _, word := range n.BlockedWords {
+		if word = normalizeLeet(word); len(word) > 0 {
+			blocked[word] = true
+		}
+	}
+	if len(blocked) == 0 {
+		return false
+	}
+
+	words := strings.FieldsFunc(nickname, func(r rune) bool {
+		return strings.ContainsRune(" _-.", r)
+	})
+	for i := range words {
+		if blocked[normalizeLeet(strings.TrimRightFunc(words[i], unicode.IsDigit))] {
+			return true
+		}
+
+		joined := ""
+		for _, word := range words[i:] {
+			joined += normalizeLeet(word)
+			if blocked[joined] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func normalizeLeet(s string) string {
+	s = leetReplacer.Replace(strings.ToLower(s))
+
+	var b strings.Builder
+	for _, r := range s {
+		if unicode.IsLetter(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Suggest returns a variation of nickname not used in the room, or an empty
+// string if none could be found. The caller holds the room's lock
+func (n *NicknamePolicy) Suggest(nickname string, room *Room) string {
+	for i := 2; i < 100; i++ {
+		suffix := fmt.Sprintf("%d", i)
+
+		base := []rune(nickname)
+		if n.MaxLength-len(suffix) < 1 {
+			return ""
+		}
+		if len(base)+len(suffix) > n.MaxLength {
+			base = base[:n.MaxLength-len(suffix)]
+		}
+
+		candidate := string(base) + suffix
+		if !nicknameTaken(room, candidate) {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// nicknameTaken reports whether a player in the room already uses nickname,
+// ignoring case. The caller holds the room's lock
+func nicknameTaken(room *Room, nickname string) bool {
+	for _, p := range room.Players {
+		if strings.EqualFold(p.Nickname, nickname) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,172 +2,266 @@ package ws
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"time"
 
 	"slices"
 
 	"github.com/coder/websocket"
+	"github.com/enzofalone/kahoot/internal/config"
+	"github.com/enzofalone/kahoot/internal/metrics"
 )
 
 type PlayerHandler struct {
-	logf        func(format string, args ...interface{})
-	rooms       map[string]*Room
+	logger      *slog.Logger
+	rooms       *Rooms
 	broadcaster *Broadcaster
+	nicknames   *NicknamePolicy
+	limits      Limits
+	codes       RoomCodeFormat
+	guard       *JoinGuard
+	cfg         *config.Config
 }
 
-func NewPlayerHandler(logf func(format string, args ...interface{}), rooms map[string]*Room) *PlayerHandler {
+func NewPlayerHandler(logger *slog.Logger, cfg *config.Config, rooms *Rooms, broadcaster *Broadcaster, nicknames *NicknamePolicy, limits Limits, codes RoomCodeFormat, guard *JoinGuard) *PlayerHandler {
 	return &PlayerHandler{
-		logf:        logf,
+		logger:      logger,
 		rooms:       rooms,
-		broadcaster: NewBroadcaster(logf),
+		broadcaster: broadcaster,
+		nicknames:   nicknames,
+		limits:      limits,
+		codes:       codes,
+		guard:       guard,
+		cfg:         cfg,
 	}
 }
 
 func (p PlayerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	attempt, allowed := guardJoin(p.guard, w, r)
+	if !allowed {
+		return
+	}
+	defer attempt.release()
+
 	c, err := websocket.Accept(w, r, &websocket.AcceptOptions{
-		Subprotocols:   []string{"kahoot-player"},
-		OriginPatterns: []string{"127.0.0.1:5173"},
+		Subprotocols:   subprotocols(SUBPROTOCOL_PLAYER),
+		OriginPatterns: p.cfg.Server.OriginPatterns(),
 	})
 	if err != nil {
-		p.logf("%v", err)
+		p.logger.Error("failed to accept websocket", "err", err)
 		return
 	}
 	defer c.CloseNow()
 
-	if c.Subprotocol() != "kahoot-player" {
-		c.Close(websocket.StatusPolicyViolation, "client must speak the echo subprotocol")
+	protocol, ok := negotiate(SUBPROTOCOL_PLAYER, c.Subprotocol())
+	if !ok {
+		c.Close(websocket.StatusPolicyViolation, "client must speak the kahoot-player subprotocol")
 		return
 	}
+	p.broadcaster.Add(c, metrics.ROLE_PLAYER, protocol, p.logger.With("role", metrics.ROLE_PLAYER, "remote", r.RemoteAddr))
+	defer p.broadcaster.Remove(c)
 
-	// Get room and player IDs from headers
-	playerID := r.Header.Get("Player-ID")
-	if len(playerID) == 0 {
-		c.Close(websocket.StatusPolicyViolation, "Player ID is required")
-		return
+	// Get room ID and nickname from headers, Player-ID is kept for older clients
+	nickname := r.Header.Get("Nickname")
+	if len(nickname) == 0 {
+		nickname = r.Header.Get("Player-ID")
 	}
+	nickname = p.nicknames.Normalize(nickname)
 
-	roomID := sanitizeRoomCode(r.Header.Get("Room-ID"))
+	roomID := p.codes.Sanitize(r.Header.Get("Room-ID"))
+	logger := p.logger.With(LOG_ROOM, roomID)
 	if len(roomID) == 0 {
-		c.Close(websocket.StatusPolicyViolation, "Room ID is required")
+		p.rejectJoin(c, JoinRejected{Reason: REJECT_ROOM_REQUIRED, Message: "Room ID is required"})
 		return
 	}
 
 	// Check if room exists
-	room, exists := p.rooms[roomID]
+	room, exists := p.rooms.Get(roomID)
 	if !exists {
-		c.Close(websocket.StatusPolicyViolation, "Room not found")
+		attempt.fail()
+		p.rejectJoin(c, JoinRejected{Reason: REJECT_ROOM_NOT_FOUND, Message: "Room not found"})
 		return
 	}
+	attempt.release()
 
-	// Check for duplicate player ID
-	for _, existingPlayer := range room.Players {
-		if existingPlayer.ID == playerID {
-			c.Close(websocket.StatusPolicyViolation, "Player ID already exists in room")
-			return
-		}
+	var nErr *JoinError
+	if err := p.nicknames.Validate(nickname); errors.As(err, &nErr) {
+		p.rejectJoin(c, JoinRejected{Reason: nErr.Reason, Message: nErr.Message})
+		return
 	}
 
-	// Create and add player to room
+	// Create and add player to room, bans and duplicate nicknames are checked there
 	player := &Player{
-		ID:     playerID,
-		Points: 0,
-		Conn:   c,
+		ID:        generatePlayerID(),
+		Nickname:  nickname,
+		SessionID: r.Header.Get("Session-ID"),
+		Points:    0,
+		Conn:      c,
 	}
+	playerID := player.ID
+	logger = logger.With(LOG_PLAYER, playerID)
 
 	if err := p.addPlayerToRoom(player, roomID); err != nil {
-		p.logf("Failed to add player to room: %v", err)
-		c.Close(websocket.StatusPolicyViolation, "Failed to join room")
+		logger.Error("Failed to add player to room", "err", err)
+		if errors.As(err, &nErr) {
+			p.rejectJoin(c, JoinRejected{Reason: nErr.Reason, Message: nErr.Message, Suggestion: nErr.Suggestion})
+		} else {
+			c.Close(websocket.StatusPolicyViolation, "Failed to join room")
+		}
 		return
 	}
-	defer p.removePlayerFromRoom(player.ID, roomID)
+	defer p.leaveRoom(room, player, logger)
+	metrics.Connections.WithLabelValues(metrics.ROLE_PLAYER).Inc()
+	defer metrics.Connections.WithLabelValues(metrics.ROLE_PLAYER).Dec()
 
-	// Send join confirmation
+	// Send join confirmation to the host and the player so it learns its ID
 	joinConfirm := &Event[PlayerJoin]{
 		Event: EVENT_JOIN,
 		Content: PlayerJoin{
 			PlayerId: playerID,
+			Nickname: nickname,
 		},
 	}
 
-	joinConfirmJson, err := json.Marshal(joinConfirm)
-	if err != nil {
-		p.logf("Failed to marshal join confirmation: %v", err)
+	if err := p.broadcaster.SendTo(c, joinConfirm); err != nil {
+		logger.Error("Failed to send join confirmation", "err", err)
 		return
 	}
-
-	if err := p.broadcaster.SendTo(room.HostConn, joinConfirmJson); err != nil {
-		p.logf("Failed to send join confirmation: %v", err)
+	if err := p.broadcaster.SendToHost(room, joinConfirm); err != nil {
+		logger.Error("Failed to send join confirmation", "err", err)
 		return
 	}
 
+	updateControllers(p.broadcaster, room)
+
+	if err := p.syncLateJoin(room, player); err != nil {
+		logger.Error("Failed to sync late joiner", "err", err)
+	}
+
+	// let everyone know which teams exist and where the new player landed
+	if teamsEnabled(room) {
+		if err := broadcastTeams(p.broadcaster, room); err != nil {
+			logger.Error("Failed to send team roster", "err", err)
+		}
+	}
+
+	if p.limits.MaxMessageSize > 0 {
+		c.SetReadLimit(p.limits.MaxMessageSize)
+	}
+	limiter := newConnLimiter(p.limits.PlayerEvents)
+
+	alive, stopHeartbeat := context.WithCancel(context.Background())
+	defer stopHeartbeat()
+	go heartbeat(alive, c, p.limits.PingInterval, p.limits.PongTimeout, func() {
+		room.markDisconnected(player)
+		logger.Warn("Player stopped answering pings, disconnecting")
+		metrics.HeartbeatTimeouts.WithLabelValues(metrics.ROLE_PLAYER).Inc()
+	})
+
 	// Handle player events
 	for {
 		_, reader, err := c.Reader(context.Background())
 		if err != nil {
-			p.logf("Failed to get reader: %v", err)
+			logger.Error("Failed to get reader", "err", err)
 			break
 		}
 
 		message, err := io.ReadAll(reader)
 		if err != nil {
-			p.logf("Failed to read message: %v", err)
+			logger.Error("Failed to read message", "err", err)
+			break
+		}
+
+		if allowed, disconnect := limiter.allow(); disconnect {
+			logger.Warn("Player exceeded the rate limit, disconnecting")
+			c.Close(websocket.StatusPolicyViolation, "Too many messages")
 			break
+		} else if !allowed {
+			// decoded only to tell the client which request was dropped
+			event, _ := decodeEvent(message, metrics.ROLE_PLAYER, protocol)
+			reply(p.broadcaster, c, event, errRateLimited)
+			continue
 		}
 
-		var event Event[json.RawMessage]
-		if err := json.Unmarshal(message, &event); err != nil {
-			p.logf("Failed to unmarshal event: %v", err)
+		event, err := decodeEvent(message, metrics.ROLE_PLAYER, protocol)
+		if err != nil {
+			logger.Warn("Rejected event", LOG_EVENT, event.Name, "err", err)
+			reply(p.broadcaster, c, event, err)
 			continue
 		}
 
 		// Handle player events
-		switch event.Event {
+		switch event.Name {
+		case EVENT_JOIN_TEAM:
+			if err = p.joinTeam(playerID, event.Content.(*PlayerJoinTeam).TeamID, roomID); err != nil {
+				logger.Error("Failed to join team", LOG_EVENT, event.Name, "err", err)
+			}
 		case EVENT_ANSWER:
-			var answerEvent Event[PlayerAnswer]
-			if err := json.Unmarshal(message, &answerEvent); err != nil {
-				p.logf("Failed to unmarshall answer: %v", err)
+			if err = p.answerQuestion(playerID, event.Content.(*PlayerAnswer).Answer, roomID); err != nil {
+				logger.Error("Failed to process answer", LOG_EVENT, event.Name, "err", err)
 			}
-
-			answer := answerEvent.Content.Answer
-			if err := p.answerQuestion(playerID, answer, roomID); err != nil {
-				p.logf("Failed to process answer: %v", err)
+		case EVENT_REPLAY:
+			if err = replay(p.broadcaster, c, room, metrics.ROLE_PLAYER, event.Content.(*ReplayRequest).Since); err != nil {
+				logger.Warn("Failed to replay events", LOG_EVENT, event.Name, "err", err)
 			}
 		}
+		reply(p.broadcaster, c, event, err)
 	}
+}
+
+// leaveRoom removes a player whose connection ended and tells the host and
+// controllers. It runs once, deferred when the player joins
+func (p PlayerHandler) leaveRoom(room *Room, player *Player, logger *slog.Logger) {
+	// player was kicked by the host, which has already been notified, or the room is closed
+	if err := p.removePlayerFromRoom(player.ID, room.ID); err != nil {
+		return
+	}
+	updateControllers(p.broadcaster, room)
 
 	// notify host player has disconnected
+	reason := DISCONNECT_CLOSED
+	if player.Disconnected.Load() {
+		reason = DISCONNECT_TIMEOUT
+	}
 	disconnectEvent := &Event[PlayerDisconnect]{
 		Event: EVENT_DISCONNECT,
 		Content: PlayerDisconnect{
-			ID: player.ID,
+			ID:     player.ID,
+			Reason: reason,
 		},
 	}
 
-	disconnectEventJson, err := json.Marshal(&disconnectEvent)
-	if err != nil {
-		p.logf("Error while marshalling disconnect event for %s to host in room %s", playerID, room.ID)
-		return
+	if err := p.broadcaster.SendToHost(room, disconnectEvent); err != nil {
+		logger.Error("Failed to send disconnect event to host", "err", err)
 	}
+}
 
-	if err := p.broadcaster.SendTo(room.HostConn, disconnectEventJson); err != nil {
-		p.logf("Failed to send disconnect event to host: %v", err)
-		return
+// rejectJoin tells the client why it could not join and closes the connection
+func (p PlayerHandler) rejectJoin(c *websocket.Conn, rejection JoinRejected) {
+	e := &Event[JoinRejected]{
+		Event:   EVENT_JOIN_REJECTED,
+		Content: rejection,
+	}
+
+	if err := p.broadcaster.SendTo(c, e); err != nil {
+		p.logger.Error("Failed to send join rejection", "err", err)
 	}
+
+	p.broadcaster.Close(c, websocket.StatusPolicyViolation, rejection.Message)
 }
 
 func (p PlayerHandler) answerQuestion(playerID string, answer string, roomID string) error {
-	room, exists := p.rooms[roomID]
+	room, exists := p.rooms.Get(roomID)
 	if !exists {
-		p.logf("answerQuestion: room %s does not exist!", roomID)
 		return fmt.Errorf("room %s does not exist for player %s", roomID, playerID)
 	}
 
 	var player *Player
-	for _, p := range room.Players {
+	for _, p := range room.players() {
 		if p.ID == playerID {
 			player = p
 			break
@@ -175,19 +269,23 @@ func (p PlayerHandler) answerQuestion(playerID string, answer string, roomID str
 	}
 
 	if player == nil {
-		p.logf("answerQuestion: player %s does not exist in room %s!", playerID, roomID)
 		return fmt.Errorf("player %s does not exist in room %s", playerID, roomID)
 	}
 
+	if !canAnswer(room, player) {
+		return eventError(ERR_NOT_ALLOWED, "player %s joined late and can answer from the next question", playerID)
+	}
+
 	// Check if player has already answered this question
 	if slices.Contains(room.Question.Answers, playerID) {
-		p.logf("answerQuestion: player %s already answered question %d", playerID, room.Question.Index)
-		return fmt.Errorf("player %s already answered this question", playerID)
+		return eventError(ERR_ALREADY_ANSWERED, "player %s already answered this question", playerID)
 	}
 
+	metrics.AnswerLatency.Observe(time.Since(room.Question.PostedAt).Seconds())
+
 	// Calculate score if the user answered correctly
 	if answer == room.Bank.Questions[room.Question.Index].CorrectAnswer {
-		player.Points += calculateScore(room.Question.PostedAt)
+		player.Points += calculateScore(room.Question.PostedAt, p.cfg.Game)
 	}
 
 	room.Question.Answers = append(room.Question.Answers, player.ID)
@@ -201,37 +299,82 @@ func (p PlayerHandler) answerQuestion(playerID string, answer string, roomID str
 		},
 	}
 
-	answerEventJson, err := json.Marshal(answerEvent)
-	if err != nil {
-		return fmt.Errorf("error marshalling answer event confirmation json %w", err)
+	// the answer is recorded, failing to tell about it must not make the player
+	// retry an answer that would then be rejected as a duplicate
+	if err := p.broadcaster.SendTo(player.Conn, answerEvent); err != nil {
+		p.logger.Error("Failed to send answer confirmation", LOG_ROOM, roomID, LOG_PLAYER, playerID, "err", err)
 	}
-
-	if err := p.broadcaster.SendToArray([]*websocket.Conn{p.rooms[roomID].HostConn, player.Conn}, answerEventJson); err != nil {
-		p.logf("Failed to send answer event to host: %v", err)
-		return err
+	if err := p.broadcaster.SendToHost(room, answerEvent); err != nil {
+		p.logger.Error("Failed to send answer event to host", LOG_ROOM, roomID, LOG_PLAYER, playerID, "err", err)
 	}
+	updateControllers(p.broadcaster, room)
 
 	return nil
 }
 
 func (p PlayerHandler) addPlayerToRoom(player *Player, roomID string) error {
-	room, exists := p.rooms[roomID]
+	room, exists := p.rooms.Get(roomID)
 	if !exists {
-		return fmt.Errorf("room %s not found", roomID)
+		return &JoinError{Reason: REJECT_ROOM_NOT_FOUND, Message: "Room not found"}
+	}
+
+	full := serverFull(p.rooms, p.limits)
+
+	// bans, nickname and capacity are checked and the player added under the
+	// same lock so two players cannot both take the last spot or the same name
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if room.Bans.isBanned(player.Nickname, player.SessionID) {
+		return &JoinError{Reason: REJECT_BANNED, Message: "You have been banned from this room"}
+	}
+
+	if nicknameTaken(room, player.Nickname) {
+		return &JoinError{
+			Reason:     REJECT_NICKNAME_TAKEN,
+			Message:    "Nickname already exists in room",
+			Suggestion: p.nicknames.Suggest(player.Nickname, room),
+		}
+	}
+
+	if err := canJoin(room, full); err != nil {
+		return err
+	}
+	prepareLateJoin(room, player)
+	if room.Teams.Enabled && room.Teams.Assign == TEAM_ASSIGN_AUTO {
+		player.TeamID = smallestTeam(room)
 	}
 
 	room.Players = append(room.Players, player)
 	return nil
 }
 
+// joinTeam lets a player pick their own team when the room assigns teams manually
+func (p PlayerHandler) joinTeam(playerID string, teamID string, roomID string) error {
+	room, exists := p.rooms.Get(roomID)
+	if !exists {
+		return fmt.Errorf("room %s not found", roomID)
+	}
+
+	if err := chooseTeam(room, playerID, teamID); err != nil {
+		return err
+	}
+
+	return broadcastTeams(p.broadcaster, room)
+}
+
 func (p PlayerHandler) removePlayerFromRoom(playerID string, roomID string) error {
-	room, exists := p.rooms[roomID]
+	room, exists := p.rooms.Get(roomID)
 	if !exists {
 		return fmt.Errorf("room %s not found", roomID)
 	}
 
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
 	for i, player := range room.Players {
 		if player.ID == playerID {
+			keepTeamPoints(room, player)
 			room.Players = slices.Delete(room.Players, i, i+1)
 			return nil
 		}
@@ -241,11 +384,11 @@ func (p PlayerHandler) removePlayerFromRoom(playerID string, roomID string) erro
 }
 
 // calculateScore determines points based on how quickly the player answered
-func calculateScore(questionPostedAt time.Time) int {
+func calculateScore(questionPostedAt time.Time, game config.Game) int {
 	elapsed := time.Since(questionPostedAt)
-	maxScore := 1000
-	minScore := 100
-	timeLimit := 30 * time.Second
+	maxScore := game.MaxScore
+	minScore := game.MinScore
+	timeLimit := game.QuestionTime
 
 	if elapsed >= timeLimit {
 		return minScore
@@ -0,0 +1,264 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/coder/websocket"
+	"github.com/enzofalone/kahoot/internal/metrics"
+	"github.com/fxamacker/cbor/v2"
+)
+
+// PROTOCOL_VERSION is the newest version of the event protocol. Clients pick
+// a version with the subprotocol they offer, e.g. kahoot-player.v1, offering
+// the bare name is treated as version 1
+const PROTOCOL_VERSION = 1
+
+// Subprotocols of each role
+const (
+	SUBPROTOCOL_HOST       = "kahoot"
+	SUBPROTOCOL_PLAYER     = "kahoot-player"
+	SUBPROTOCOL_SPECTATOR  = "kahoot-spectator"
+	SUBPROTOCOL_CONTROLLER = "kahoot-controller"
+)
+
+// Codes of the errors sent back to clients in event_error
+const (
+	ERR_MALFORMED          = "malformed"          // not a JSON event
+	ERR_UNKNOWN_EVENT      = "unknown_event"      // event is not in the registry
+	ERR_FORBIDDEN          = "forbidden"          // event cannot be sent by this role or protocol version
+	ERR_INVALID            = "invalid_payload"    // content does not match the payload of the event
+	ERR_NOT_FOUND          = "not_found"          // room, player or team the event refers to does not exist
+	ERR_WRONG_STATE        = "wrong_state"        // event cannot be handled in the current phase of the game
+	ERR_NOT_ALLOWED        = "not_allowed"        // the room's settings do not let the client do this
+	ERR_TEAMS_DISABLED     = "teams_disabled"     // team event sent to a room that is not in team mode
+	ERR_ALREADY_ANSWERED   = "already_answered"   // player already answered the current question
+	ERR_SKIP_USED          = "skip_used"          // current question was already skipped
+	ERR_RESTARTING         = "server_restarting"  // server is shutting down and takes no new questions
+	ERR_REPLAY_UNAVAILABLE = "replay_unavailable" // missed events are no longer kept, the client has to resync
+	ERR_RATE_LIMITED       = "rate_limited"       // client sent too many events, the event was dropped
+	ERR_FAILED             = "failed"             // event was valid but handling it failed
+)
+
+// Protocol is what a connection negotiated when it was accepted
+type Protocol struct {
+	Version  int
+	Encoding string
+}
+
+// subprotocols lists the subprotocols accepted for a role, newest first so
+// clients offering several get the newest the server speaks. CBOR comes
+// before JSON for each version since clients only offer it when they opt in
+func subprotocols(base string) []string {
+	names := make([]string, 0, 2*PROTOCOL_VERSION+1)
+	for v := PROTOCOL_VERSION; v >= 1; v-- {
+		name := base + ".v" + strconv.Itoa(v)
+		names = append(names, name+"+"+ENCODING_CBOR, name)
+	}
+	return append(names, base)
+}
+
+// negotiate parses the subprotocol picked for a connection, reporting false
+// when the client did not offer one the role supports
+func negotiate(base string, subprotocol string) (Protocol, bool) {
+	if subprotocol == base {
+		return Protocol{Version: 1, Encoding: ENCODING_JSON}, true
+	}
+
+	encoding := ENCODING_JSON
+	if name, found := strings.CutSuffix(subprotocol, "+"+ENCODING_CBOR); found {
+		subprotocol = name
+		encoding = ENCODING_CBOR
+	}
+
+	version, found := strings.CutPrefix(subprotocol, base+".v")
+	if !found {
+		return Protocol{}, false
+	}
+	v, err := strconv.Atoi(version)
+	if err != nil || v < 1 || v > PROTOCOL_VERSION {
+		return Protocol{}, false
+	}
+	return Protocol{Version: v, Encoding: encoding}, true
+}
+
+// EventSpec describes an event of the protocol. Some names go both ways
+// with a different content, such as event_start which hosts send to start
+// the game and the server sends back with the game's length
+type EventSpec struct {
+	Since int // first protocol version with the event
+
+	Senders []string   // roles that may send the event to the server
+	In      func() any // new value to decode the content clients send into, nil when it has none
+
+	Receivers []string   // roles the server may send the event to, enforced by the broadcaster
+	Out       func() any // content the server sends, nil when it has none
+}
+
+// validator is implemented by the content of client events that needs more
+// checks than decoding before the event is handled
+type validator interface {
+	validate() error
+}
+
+func payload[T any]() func() any {
+	return func() any { return new(T) }
+}
+
+var (
+	hostRoles    = []string{metrics.ROLE_HOST}
+	controlRoles = []string{metrics.ROLE_HOST, metrics.ROLE_CONTROLLER}
+	playerRoles  = []string{metrics.ROLE_PLAYER}
+	viewerRoles  = []string{metrics.ROLE_HOST, metrics.ROLE_SPECTATOR}
+	roomRoles    = []string{metrics.ROLE_HOST, metrics.ROLE_PLAYER, metrics.ROLE_SPECTATOR}
+	everyone     = []string{metrics.ROLE_HOST, metrics.ROLE_PLAYER, metrics.ROLE_SPECTATOR, metrics.ROLE_CONTROLLER}
+)
+
+// registry holds every event of the protocol
+var registry = map[string]EventSpec{
+	EVENT_ROOM_CREATED:    {Since: 1, Receivers: hostRoles, Out: payload[RoomCreated]()},
+	EVENT_START:           {Since: 1, Senders: controlRoles, Receivers: roomRoles, Out: payload[Start]()},
+	EVENT_NEXT:            {Since: 1, Senders: controlRoles},
+	EVENT_NEXT_READY:      {Since: 1, Receivers: hostRoles},
+	EVENT_QUESTION:        {Since: 1, Receivers: roomRoles, Out: payload[QuestionPublic]()},
+	EVENT_QUESTION_PROMPT: {Since: 1, Receivers: roomRoles, Out: payload[Prompt]()},
+	EVENT_REVEAL:          {Since: 1, Senders: hostRoles, Receivers: roomRoles, Out: payload[Reveal]()},
+	EVENT_REVEAL_SCORE:    {Since: 1, Receivers: roomRoles, Out: payload[RevealScore]()},
+	EVENT_FINISH:          {Since: 1, Receivers: roomRoles, Out: payload[Finish]()},
+	EVENT_ALL_ANSWERED:    {Since: 1, Receivers: roomRoles, Out: payload[AllAnswered]()},
+	EVENT_SKIP_QUESTION:   {Since: 1, Senders: controlRoles},
+	EVENT_ANSWER:          {Since: 1, Senders: playerRoles, In: payload[PlayerAnswer](), Receivers: roomRoles, Out: payload[PlayerAnswerConfirmation]()},
+	EVENT_JOIN:            {Since: 1, Receivers: roomRoles, Out: payload[PlayerJoin]()},
+	EVENT_DISCONNECT:      {Since: 1, Receivers: viewerRoles, Out: payload[PlayerDisconnect]()},
+	EVENT_CONFIGURE_TEAMS: {Since: 1, Senders: hostRoles, In: payload[TeamConfig]()},
+	EVENT_ASSIGN_TEAM:     {Since: 1, Senders: hostRoles, In: payload[TeamAssign]()},
+	EVENT_JOIN_TEAM:       {Since: 1, Senders: playerRoles, In: payload[PlayerJoinTeam]()},
+	EVENT_TEAM_UPDATE:     {Since: 1, Receivers: roomRoles, Out: payload[TeamUpdate]()},
+	EVENT_JOIN_REJECTED:   {Since: 1, Receivers: playerRoles, Out: payload[JoinRejected]()},
+	EVENT_KICK:            {Since: 1, Senders: hostRoles, In: payload[PlayerRemoval]()},
+	EVENT_BAN:             {Since: 1, Senders: hostRoles, In: payload[PlayerRemoval]()},
+	EVENT_KICKED:          {Since: 1, Receivers: roomRoles, Out: payload[PlayerKicked]()},
+	EVENT_ROOM_SETTINGS:   {Since: 1, Senders: hostRoles, In: payload[RoomSettings](), Receivers: hostRoles, Out: payload[RoomSettings]()},
+	EVENT_SYNC:            {Since: 1, Receivers: playerRoles, Out: payload[GameSync]()},
+	EVENT_SPECTATE:        {Since: 1, Receivers: []string{metrics.ROLE_SPECTATOR}, Out: payload[SpectatorJoined]()},
+	EVENT_SPECTATORS:      {Since: 1, Receivers: hostRoles, Out: payload[SpectatorCount]()},
+	EVENT_STATUS:          {Since: 1, Receivers: []string{metrics.ROLE_CONTROLLER}, Out: payload[ControllerStatus]()},
+	EVENT_RESTARTING:      {Since: 1, Receivers: everyone, Out: payload[ServerRestarting]()},
+	EVENT_ACK:             {Since: 1, Receivers: everyone, Out: payload[Ack]()},
+	EVENT_ERROR:           {Since: 1, Receivers: everyone, Out: payload[EventFailure]()},
+	EVENT_REPLAY:          {Since: 1, Senders: roomRoles, In: payload[ReplayRequest]()},
+}
+
+// mayReceive reports whether the registry lets role receive an event
+func mayReceive(role string, event string) bool {
+	return slices.Contains(registry[event].Receivers, role)
+}
+
+// EventError describes why a client event was rejected
+type EventError struct {
+	Code    string
+	Message string
+}
+
+func (e *EventError) Error() string {
+	return e.Message
+}
+
+// eventError returns an error that is sent back to the client with code
+func eventError(code string, format string, args ...any) *EventError {
+	return &EventError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// ClientEvent is a decoded client event
+type ClientEvent struct {
+	Name      string
+	RequestID string // set by clients to match the reply to the event
+	Content   any    // pointer to the payload of the event, nil for events without content
+}
+
+// decodeEvent is the single path client events go through. It checks the
+// event exists, that role may send it with the negotiated protocol and
+// decodes and validates its content. The name and request ID are returned
+// with the error when they could be read, so the client can be told
+func decodeEvent(message []byte, role string, protocol Protocol) (ClientEvent, error) {
+	if protocol.Encoding == ENCODING_CBOR {
+		return decodeWith[cbor.RawMessage](message, role, protocol, cbor.Unmarshal)
+	}
+	return decodeWith[json.RawMessage](message, role, protocol, json.Unmarshal)
+}
+
+// decodeWith decodes an event with the unmarshal function of an encoding,
+// R being the raw message type of the encoding that holds the content
+func decodeWith[R ~[]byte](message []byte, role string, protocol Protocol, unmarshal func([]byte, any) error) (ClientEvent, error) {
+	var event Event[R]
+	if err := unmarshal(message, &event); err != nil {
+		countReceived("")
+		return ClientEvent{}, eventError(ERR_MALFORMED, "event could not be decoded as %s: %v", protocol.Encoding, err)
+	}
+	countReceived(event.Event)
+
+	decoded := ClientEvent{Name: event.Event, RequestID: event.RequestID}
+
+	spec, exists := registry[event.Event]
+	if !exists || len(spec.Senders) == 0 {
+		return decoded, eventError(ERR_UNKNOWN_EVENT, "unknown event %q", event.Event)
+	}
+	if !slices.Contains(spec.Senders, role) || protocol.Version < spec.Since {
+		return decoded, eventError(ERR_FORBIDDEN, "event %q cannot be sent by a %s", event.Event, role)
+	}
+
+	if spec.In == nil {
+		return decoded, nil
+	}
+
+	content := spec.In()
+	if err := unmarshal(event.Content, content); err != nil {
+		return decoded, eventError(ERR_INVALID, "invalid content for %q: %v", event.Event, err)
+	}
+	if v, ok := content.(validator); ok {
+		if err := v.validate(); err != nil {
+			return decoded, eventError(ERR_INVALID, "invalid content for %q: %v", event.Event, err)
+		}
+	}
+	decoded.Content = content
+	return decoded, nil
+}
+
+// errRateLimited is replied to events dropped by the rate limit of a connection
+var errRateLimited = eventError(ERR_RATE_LIMITED, "too many events, slow down")
+
+// reply tells a client whether its event was handled. Failures are always
+// reported, successes only when the client asked for a reply with a request ID
+func reply(b *Broadcaster, conn *websocket.Conn, event ClientEvent, err error) {
+	var message Outgoing
+
+	if err != nil {
+		eErr := &EventError{Code: ERR_FAILED, Message: err.Error()}
+		errors.As(err, &eErr)
+
+		message = &Event[EventFailure]{
+			Event: EVENT_ERROR,
+			Content: EventFailure{
+				RequestID: event.RequestID,
+				Event:     event.Name,
+				Code:      eErr.Code,
+				Message:   eErr.Message,
+			},
+		}
+	} else if event.RequestID != "" {
+		message = &Event[Ack]{
+			Event:   EVENT_ACK,
+			Content: Ack{RequestID: event.RequestID, Event: event.Name},
+		}
+	} else {
+		return
+	}
+
+	if err := b.SendTo(conn, message); err != nil {
+		b.logger.Warn("reply: failed to send", LOG_EVENT, event.Name, "err", err)
+	}
+}
@@ -0,0 +1,63 @@
+package ws
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+
+	"github.com/coder/websocket"
+	"github.com/enzofalone/kahoot/internal/metrics"
+)
+
+var roles = []string{metrics.ROLE_HOST, metrics.ROLE_PLAYER, metrics.ROLE_SPECTATOR, metrics.ROLE_CONTROLLER}
+
+func TestRegistryDirections(t *testing.T) {
+	for name, spec := range registry {
+		if len(spec.Senders) == 0 && len(spec.Receivers) == 0 {
+			t.Errorf("%s: neither sent nor received by any role", name)
+		}
+		if spec.In != nil && len(spec.Senders) == 0 {
+			t.Errorf("%s: has client content but no role may send it", name)
+		}
+		if spec.Out != nil && len(spec.Receivers) == 0 {
+			t.Errorf("%s: has server content but no role receives it", name)
+		}
+
+		for _, role := range slices.Concat(spec.Senders, spec.Receivers) {
+			if !slices.Contains(roles, role) {
+				t.Errorf("%s: unknown role %q", name, role)
+			}
+		}
+	}
+}
+
+func TestBroadcasterOnlySendsToReceivers(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	b := NewBroadcaster(logger, Limits{SendQueue: len(registry)})
+
+	for _, role := range roles {
+		// the writer is not started, queued frames stay in the outbox
+		conn := &websocket.Conn{}
+		b.outboxes[conn] = &outbox{
+			conn:     conn,
+			logger:   logger,
+			role:     role,
+			encoding: ENCODING_JSON,
+			queue:    make(chan outboundMessage, len(registry)),
+			done:     make(chan struct{}),
+			stop:     make(chan struct{}),
+		}
+
+		for name, spec := range registry {
+			err := b.enqueue(conn, newFrame(&Event[struct{}]{Event: name}))
+			if slices.Contains(spec.Receivers, role) && err != nil {
+				t.Errorf("%s to %s: got %v, want it sent", name, role, err)
+			}
+			if !slices.Contains(spec.Receivers, role) && !errors.Is(err, errNotReceiver) {
+				t.Errorf("%s to %s: got %v, want %v", name, role, err, errNotReceiver)
+			}
+		}
+	}
+}
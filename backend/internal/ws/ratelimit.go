@@ -0,0 +1,59 @@
+package ws
+
+import (
+	"time"
+
+	"github.com/enzofalone/kahoot/internal/metrics"
+	"golang.org/x/time/rate"
+)
+
+// RateLimit configures the token bucket applied to the events of a single connection
+type RateLimit struct {
+	Every         time.Duration // one event is allowed every Every
+	Burst         int           // events allowed at once
+	MaxViolations int           // dropped events within Window before disconnecting
+	Window        time.Duration
+}
+
+// connLimiter enforces a RateLimit on one connection
+type connLimiter struct {
+	limit       RateLimit
+	limiter     *rate.Limiter
+	violations  int
+	windowStart time.Time
+}
+
+func newConnLimiter(limit RateLimit) *connLimiter {
+	l := rate.Inf
+	if limit.Every > 0 {
+		l = rate.Every(limit.Every)
+	}
+
+	return &connLimiter{
+		limit:   limit,
+		limiter: rate.NewLimiter(l, limit.Burst),
+	}
+}
+
+// allow reports whether an event may be handled, and whether the connection
+// has exceeded its limit often enough that it should be closed
+func (c *connLimiter) allow() (allowed bool, disconnect bool) {
+	if c.limiter.Allow() {
+		return true, false
+	}
+
+	metrics.RateLimitedMessages.Inc()
+
+	now := time.Now()
+	if now.Sub(c.windowStart) > c.limit.Window {
+		c.windowStart = now
+		c.violations = 0
+	}
+	c.violations++
+
+	if c.limit.MaxViolations > 0 && c.violations >= c.limit.MaxViolations {
+		metrics.RateLimitedDisconnects.Inc()
+		return false, true
+	}
+	return false, false
+}
@@ -0,0 +1,70 @@
+package ws
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/coder/websocket"
+)
+
+// EventLog numbers the events sent to a room and keeps the latest ones so
+// clients that lagged or reconnected can ask for the ones they missed
+type EventLog struct {
+	mu      sync.Mutex
+	seq     uint64        // of the last event sent to the room
+	entries []loggedEvent // ring buffer, the event with seq n is at n % len(entries)
+}
+
+type loggedEvent struct {
+	seq   uint64
+	roles []string // roles the event was sent to
+	frame *frame
+}
+
+func newEventLog(size int) *EventLog {
+	return &EventLog{entries: make([]loggedEvent, size)}
+}
+
+// record numbers an event with the next sequence number of the room and keeps
+// it for replay, returning the frame to send
+func (l *EventLog) record(e Outgoing, roles []string) *frame {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.seq++
+	f := newFrame(e.withSeq(l.seq))
+	l.entries[l.seq%uint64(len(l.entries))] = loggedEvent{seq: l.seq, roles: roles, frame: f}
+	return f
+}
+
+// since returns the events after seq that were sent to role, reporting false
+// when some of them are no longer kept and the client has to resync instead
+func (l *EventLog) since(seq uint64, role string) ([]*frame, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	size := uint64(len(l.entries))
+	if seq > l.seq || l.seq-seq > size {
+		return nil, false
+	}
+
+	var frames []*frame
+	for n := seq + 1; n <= l.seq; n++ {
+		e := l.entries[n%size]
+		if slices.Contains(e.roles, role) {
+			frames = append(frames, e.frame)
+		}
+	}
+	return frames, true
+}
+
+// replay sends a client the events of its room it missed after seq, in
+// order. Live events may arrive in between, clients drop any event whose
+// sequence number they have already seen
+func replay(b *Broadcaster, conn *websocket.Conn, room *Room, role string, seq uint64) error {
+	frames, ok := room.Events.since(seq, role)
+	if !ok {
+		return eventError(ERR_REPLAY_UNAVAILABLE, "events after %d are no longer available, resync the room", seq)
+	}
+	return b.sendBurst(conn, frames)
+}
@@ -0,0 +1,152 @@
+package ws
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// MIN_ROOM_CODES is the fewest distinct codes a format has to produce
+const MIN_ROOM_CODES = 1_000_000
+
+// ROOM_CODE_SPARSITY keeps open rooms to at most one in this many codes, so a
+// guessed code rarely names a room and new codes are found quickly
+const ROOM_CODE_SPARSITY = 1000
+
+// Room code formats
+const (
+	ROOM_CODE_NUMERIC      = "numeric"      // digits only, like a game PIN
+	ROOM_CODE_ALPHANUMERIC = "alphanumeric" // letters and digits without look-alike characters
+	ROOM_CODE_WORDS        = "words"        // dash separated words that are easy to read aloud
+)
+
+// unambiguous alphabet based on Crockford's base32, "i", "l", "o" and "u" are left out
+const unambiguousCharset = "0123456789abcdefghjkmnpqrstvwxyz"
+
+// characters players may type instead of their unambiguous counterpart
+var ambiguousReplacer = strings.NewReplacer(
+	"o", "0",
+	"i", "1",
+	"l", "1",
+	"u", "v",
+)
+
+var roomCodeWords = []string{
+	"apple", "arrow", "badge", "beach", "bison", "blaze", "bread", "brick",
+	"cabin", "camel", "candy", "cedar", "chair", "cloud", "coral", "crane",
+	"daisy", "delta", "eagle", "ember", "fable", "fern", "flame", "frost",
+	"gecko", "giant", "grape", "hazel", "heron", "honey", "ivory", "jelly",
+	"kayak", "koala", "lemon", "lilac", "lunar", "maple", "mango", "medal",
+	"melon", "mint", "moose", "night", "ocean", "olive", "orbit", "otter",
+	"panda", "pearl", "piano", "pixel", "plaza", "quail", "raven", "river",
+	"robin", "salsa", "shell", "solar", "tiger", "toast", "tulip", "zebra",
+}
+
+// RoomCodeFormat controls how room codes are generated and read back
+type RoomCodeFormat struct {
+	Kind   string
+	Length int // number of characters, or number of words for ROOM_CODE_WORDS
+}
+
+func DefaultRoomCodeFormat() RoomCodeFormat {
+	return RoomCodeFormat{
+		Kind:   ROOM_CODE_ALPHANUMERIC,
+		Length: 6,
+	}
+}
+
+// Validate checks the format can produce at least MIN_ROOM_CODES distinct
+// codes, which takes 6 digits, 4 alphanumeric characters or 4 words
+func (f RoomCodeFormat) Validate() error {
+	switch f.Kind {
+	case ROOM_CODE_NUMERIC, ROOM_CODE_ALPHANUMERIC, ROOM_CODE_WORDS:
+	default:
+		return fmt.Errorf("unknown room code format %q", f.Kind)
+	}
+
+	if f.Space() < MIN_ROOM_CODES {
+		return fmt.Errorf("%s room codes of length %d give %.0f codes, at least %d are needed to resist guessing",
+			f.Kind, f.Length, f.Space(), MIN_ROOM_CODES)
+	}
+	return nil
+}
+
+// Space returns the number of distinct codes the format produces
+func (f RoomCodeFormat) Space() float64 {
+	symbols := len(unambiguousCharset)
+	switch f.Kind {
+	case ROOM_CODE_NUMERIC:
+		symbols = 10
+	case ROOM_CODE_WORDS:
+		symbols = len(roomCodeWords)
+	}
+	return math.Pow(float64(symbols), float64(f.Length))
+}
+
+// MaxRooms returns how many rooms may be open at once, keeping them to one
+// in ROOM_CODE_SPARSITY codes
+func (f RoomCodeFormat) MaxRooms() int {
+	return int(math.Min(f.Space()/ROOM_CODE_SPARSITY, math.MaxInt32))
+}
+
+// Generate returns a random room code using crypto/rand
+func (f RoomCodeFormat) Generate() (string, error) {
+	switch f.Kind {
+	case ROOM_CODE_NUMERIC:
+		return randomString("0123456789", f.Length)
+	case ROOM_CODE_WORDS:
+		words := make([]string, f.Length)
+		for i := range words {
+			n, err := rand.Int(rand.Reader, big.NewInt(int64(len(roomCodeWords))))
+			if err != nil {
+				return "", err
+			}
+			words[i] = roomCodeWords[n.Int64()]
+		}
+		return strings.Join(words, "-"), nil
+	default:
+		return randomString(unambiguousCharset, f.Length)
+	}
+}
+
+func randomString(charset string, length int) (string, error) {
+	b := make([]byte, length)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(charset))))
+		if err != nil {
+			return "", err
+		}
+		b[i] = charset[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// Sanitize turns a code typed by a player into the form produced by Generate
+func (f RoomCodeFormat) Sanitize(c string) string {
+	c = strings.ToLower(strings.TrimSpace(c))
+
+	switch f.Kind {
+	case ROOM_CODE_NUMERIC:
+		return strings.Map(func(r rune) rune {
+			if r >= '0' && r <= '9' {
+				return r
+			}
+			return -1
+		}, c)
+	case ROOM_CODE_WORDS:
+		words := strings.FieldsFunc(c, func(r rune) bool {
+			return r < 'a' || r > 'z'
+		})
+		return strings.Join(words, "-")
+	default:
+		c = strings.Map(func(r rune) rune {
+			if r == ' ' || r == '-' {
+				return -1
+			}
+			return r
+		}, c)
+		return ambiguousReplacer.Replace(c)
+	}
+}
@@ -0,0 +1,175 @@
+package ws
+
+import (
+	"errors"
+	"slices"
+	"sync"
+)
+
+var (
+	errCodeTaken    = errors.New("room code is already taken")
+	errTooManyRooms = errors.New("too many open rooms")
+)
+
+// Rooms is the registry of open rooms shared by every handler. Its lock
+// guards the map, the players of each room are guarded by the room's own lock
+type Rooms struct {
+	mu    sync.RWMutex
+	rooms map[string]*Room
+	max   int // open rooms at once, 0 means unlimited
+}
+
+// NewRooms returns a registry keeping at most max rooms open, 0 means unlimited
+func NewRooms(max int) *Rooms {
+	return &Rooms{rooms: make(map[string]*Room), max: max}
+}
+
+// Get returns the room with a code
+func (r *Rooms) Get(roomID string) (*Room, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	room, exists := r.rooms[roomID]
+	return room, exists
+}
+
+// Add registers a room. It fails with errCodeTaken when its code is already
+// used and with errTooManyRooms when the registry is full
+func (r *Rooms) Add(room *Room) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.max > 0 && len(r.rooms) >= r.max {
+		return errTooManyRooms
+	}
+	if _, exists := r.rooms[room.ID]; exists {
+		return errCodeTaken
+	}
+	r.rooms[room.ID] = room
+	return nil
+}
+
+// Remove unregisters a room and returns it. Only the caller that removed a
+// room gets it back, which makes that caller the one closing it
+func (r *Rooms) Remove(roomID string) (*Room, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	room, exists := r.rooms[roomID]
+	if exists {
+		delete(r.rooms, roomID)
+	}
+	return room, exists
+}
+
+// All returns a snapshot of the open rooms, safe to range over while rooms
+// are created and removed
+func (r *Rooms) All() []*Room {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rooms := make([]*Room, 0, len(r.rooms))
+	for _, room := range r.rooms {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+// PlayerCount returns the number of players connected across every room
+func (r *Rooms) PlayerCount() int {
+	count := 0
+	for _, room := range r.All() {
+		room.mu.Lock()
+		count += len(room.Players)
+		room.mu.Unlock()
+	}
+	return count
+}
+
+// players returns a snapshot of the players of a room
+func (room *Room) players() []*Player {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	return slices.Clone(room.Players)
+}
+
+// spectators returns a snapshot of the spectators of a room
+func (room *Room) spectators() []*Spectator {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	return slices.Clone(room.Spectators)
+}
+
+// addSpectator adds a spectator to a room, reporting false when the room
+// already has max spectators. A max of 0 means unlimited
+func (room *Room) addSpectator(spectator *Spectator, max int) bool {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if max > 0 && len(room.Spectators) >= max {
+		return false
+	}
+	room.Spectators = append(room.Spectators, spectator)
+	return true
+}
+
+// removeSpectator removes a spectator from a room, reporting false when it
+// was not in the room
+func (room *Room) removeSpectator(spectator *Spectator) bool {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	i := slices.Index(room.Spectators, spectator)
+	if i == -1 {
+		return false
+	}
+	room.Spectators = slices.Delete(room.Spectators, i, i+1)
+	return true
+}
+
+// controllers returns a snapshot of the controllers of a room
+func (room *Room) controllers() []*Controller {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	return slices.Clone(room.Controllers)
+}
+
+// addController adds a remote control to a room
+func (room *Room) addController(controller *Controller) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	room.Controllers = append(room.Controllers, controller)
+}
+
+// removeController removes a remote control from a room
+func (room *Room) removeController(controller *Controller) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	i := slices.Index(room.Controllers, controller)
+	if i == -1 {
+		return
+	}
+	room.Controllers = slices.Delete(room.Controllers, i, i+1)
+}
+
+// markDisconnected flags a player whose connection went dead, under the room's
+// lock so the question loop stops waiting for them before the connection closes
+func (room *Room) markDisconnected(player *Player) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	player.Disconnected.Store(true)
+}
+
+// playerCount returns the number of players in a room
+func (room *Room) playerCount() int {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	return len(room.Players)
+}
@@ -0,0 +1,89 @@
+package ws
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+const RESTART_MESSAGE = "Server is restarting, please rejoin in a moment"
+
+// Shutdown tells every room the server is restarting, lets running questions
+// finish for up to drain, stores the scores of unfinished games and closes
+// every connection as going away. New rooms and questions are refused from
+// the moment it is called
+func (h HostHandler) Shutdown(ctx context.Context, drain time.Duration) {
+	h.draining.Store(true)
+
+	e := &Event[ServerRestarting]{
+		Event:   EVENT_RESTARTING,
+		Content: ServerRestarting{Message: RESTART_MESSAGE},
+	}
+
+	for _, room := range h.rooms.All() {
+		if err := h.broadcaster.BroadcastAll(room, e); err != nil {
+			h.logger.Error("Shutdown: failed to notify room", LOG_ROOM, room.ID, "err", err)
+		}
+		h.broadcaster.SendToControllers(room, e)
+	}
+
+	if drain > 0 {
+		h.waitForQuestions(ctx, drain)
+	}
+
+	var wg sync.WaitGroup
+	for _, room := range h.rooms.All() {
+		roomID := room.ID
+		room.closing.Store(true)
+
+		if room.GameID > 0 && room.Question.State != EVENT_FINISH {
+			if err := h.saveResults(room); err != nil {
+				h.logger.Error("Shutdown: failed to record game", LOG_ROOM, roomID, "err", err)
+			}
+		}
+
+		wg.Add(1)
+		go func(host *websocket.Conn) {
+			defer wg.Done()
+			h.broadcaster.Close(host, websocket.StatusGoingAway, RESTART_MESSAGE)
+		}(room.HostConn)
+
+		if err := h.closeRoom(roomID, websocket.StatusGoingAway, RESTART_MESSAGE); err != nil {
+			h.logger.Error("Shutdown: failed to close room", LOG_ROOM, roomID, "err", err)
+		}
+	}
+	wg.Wait()
+}
+
+// waitForQuestions returns once no room is showing a question, drain has
+// passed or ctx is done, whichever comes first
+func (h HostHandler) waitForQuestions(ctx context.Context, drain time.Duration) {
+	deadline := time.NewTimer(drain)
+	defer deadline.Stop()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		running := 0
+		for _, room := range h.rooms.All() {
+			if room.Question.State == EVENT_QUESTION_PROMPT || room.Question.State == EVENT_QUESTION {
+				running++
+			}
+		}
+		if running == 0 {
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-deadline.C:
+			h.logger.Warn("Shutdown: questions still running after drain", "rooms", running)
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
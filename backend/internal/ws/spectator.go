@@ -0,0 +1,193 @@
+package ws
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/coder/websocket"
+	"github.com/enzofalone/kahoot/internal/config"
+	"github.com/enzofalone/kahoot/internal/metrics"
+)
+
+// Spectator is a read-only connection following the host's view of a room
+type Spectator struct {
+	ID   string
+	Conn *websocket.Conn
+}
+
+type SpectatorHandler struct {
+	logger      *slog.Logger
+	rooms       *Rooms
+	broadcaster *Broadcaster
+	limits      Limits
+	codes       RoomCodeFormat
+	guard       *JoinGuard
+	cfg         *config.Config
+}
+
+func NewSpectatorHandler(logger *slog.Logger, cfg *config.Config, rooms *Rooms, broadcaster *Broadcaster, limits Limits, codes RoomCodeFormat, guard *JoinGuard) *SpectatorHandler {
+	return &SpectatorHandler{
+		logger:      logger,
+		rooms:       rooms,
+		broadcaster: broadcaster,
+		limits:      limits,
+		codes:       codes,
+		guard:       guard,
+		cfg:         cfg,
+	}
+}
+
+func (s SpectatorHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	attempt, allowed := guardJoin(s.guard, w, r)
+	if !allowed {
+		return
+	}
+	defer attempt.release()
+
+	c, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+		Subprotocols:   subprotocols(SUBPROTOCOL_SPECTATOR),
+		OriginPatterns: s.cfg.Server.OriginPatterns(),
+	})
+	if err != nil {
+		s.logger.Error("failed to accept websocket", "err", err)
+		return
+	}
+	defer c.CloseNow()
+
+	protocol, ok := negotiate(SUBPROTOCOL_SPECTATOR, c.Subprotocol())
+	if !ok {
+		c.Close(websocket.StatusPolicyViolation, "client must speak the kahoot-spectator subprotocol")
+		return
+	}
+	s.broadcaster.Add(c, metrics.ROLE_SPECTATOR, protocol, s.logger.With("role", metrics.ROLE_SPECTATOR, "remote", r.RemoteAddr))
+	defer s.broadcaster.Remove(c)
+
+	// browsers cannot set headers on websockets, so overlays may pass the room as a query parameter
+	code := r.Header.Get("Room-ID")
+	if len(code) == 0 {
+		code = r.URL.Query().Get("room")
+	}
+
+	roomID := s.codes.Sanitize(code)
+	room, exists := s.rooms.Get(roomID)
+	if !exists {
+		attempt.fail()
+		c.Close(websocket.StatusPolicyViolation, "Room not found")
+		return
+	}
+	attempt.release()
+
+	spectator := &Spectator{
+		ID:   generatePlayerID(),
+		Conn: c,
+	}
+	if !room.addSpectator(spectator, s.limits.MaxSpectatorsPerRoom) {
+		c.Close(websocket.StatusPolicyViolation, "Room has too many spectators")
+		return
+	}
+	defer s.removeSpectator(room, spectator)
+	metrics.Connections.WithLabelValues(metrics.ROLE_SPECTATOR).Inc()
+	defer metrics.Connections.WithLabelValues(metrics.ROLE_SPECTATOR).Dec()
+
+	if err := s.sendSync(room, spectator); err != nil {
+		s.logger.Error("Failed to sync spectator", LOG_ROOM, roomID, "err", err)
+		return
+	}
+
+	if err := s.sendCount(room); err != nil {
+		s.logger.Error("Failed to send spectator count to host", LOG_ROOM, roomID, "err", err)
+	}
+
+	if s.limits.MaxMessageSize > 0 {
+		c.SetReadLimit(s.limits.MaxMessageSize)
+	}
+	limiter := newConnLimiter(s.limits.PlayerEvents)
+	logger := s.logger.With(LOG_ROOM, roomID, "spectator", spectator.ID)
+
+	// spectators are read-only, the only event they send is asking for the
+	// events they missed
+	for {
+		_, reader, err := c.Reader(context.Background())
+		if err != nil {
+			logger.Error("Failed to get reader from spectator", "err", err)
+			break
+		}
+
+		message, err := io.ReadAll(reader)
+		if err != nil {
+			logger.Error("Failed to read message from spectator", "err", err)
+			break
+		}
+
+		if allowed, disconnect := limiter.allow(); disconnect {
+			logger.Warn("Spectator exceeded the rate limit, disconnecting")
+			c.Close(websocket.StatusPolicyViolation, "Too many messages")
+			break
+		} else if !allowed {
+			// decoded only to tell the client which request was dropped
+			event, _ := decodeEvent(message, metrics.ROLE_SPECTATOR, protocol)
+			reply(s.broadcaster, c, event, errRateLimited)
+			continue
+		}
+
+		event, err := decodeEvent(message, metrics.ROLE_SPECTATOR, protocol)
+		if err != nil {
+			logger.Warn("Rejected event", LOG_EVENT, event.Name, "err", err)
+		} else if event.Name == EVENT_REPLAY {
+			if err = replay(s.broadcaster, c, room, metrics.ROLE_SPECTATOR, event.Content.(*ReplayRequest).Since); err != nil {
+				logger.Warn("Failed to replay events", LOG_EVENT, event.Name, "err", err)
+			}
+		}
+		reply(s.broadcaster, c, event, err)
+	}
+}
+
+// sendSync sends a new spectator what the room is currently showing
+func (s SpectatorHandler) sendSync(room *Room, spectator *Spectator) error {
+	sync := GameSync{
+		Phase:          room.Question.State,
+		QuestionNumber: room.Question.Index,
+		TotalQuestions: len(room.Bank.Questions),
+		Scores:         topScores(room),
+		Teams:          teamScores(room),
+	}
+
+	e := &Event[SpectatorJoined]{
+		Event: EVENT_SPECTATE,
+		Content: SpectatorJoined{
+			RoomCode: room.ID,
+			Players:  room.playerCount(),
+			Sync:     sync,
+		},
+	}
+
+	return s.broadcaster.SendTo(spectator.Conn, e)
+}
+
+// sendCount tells the host how many spectators are following the room
+func (s SpectatorHandler) sendCount(room *Room) error {
+	e := &Event[SpectatorCount]{
+		Event: EVENT_SPECTATORS,
+		Content: SpectatorCount{
+			Count: len(room.spectators()),
+		},
+	}
+
+	return s.broadcaster.SendTo(room.HostConn, e)
+}
+
+func (s SpectatorHandler) removeSpectator(room *Room, spectator *Spectator) {
+	if !room.removeSpectator(spectator) {
+		return
+	}
+
+	if _, exists := s.rooms.Get(room.ID); !exists {
+		return
+	}
+
+	if err := s.sendCount(room); err != nil {
+		s.logger.Error("Failed to send spectator count to host", LOG_ROOM, room.ID, "err", err)
+	}
+}
@@ -0,0 +1,311 @@
+package ws
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Team assignment modes
+const (
+	TEAM_ASSIGN_MANUAL = "manual" // host assigns players or players pick a team
+	TEAM_ASSIGN_AUTO   = "auto"   // players are placed in the smallest team on join
+)
+
+// Team scoring modes
+const (
+	TEAM_SCORE_SUM     = "sum"     // team score is the sum of its members' points
+	TEAM_SCORE_AVERAGE = "average" // team score is the average of its members' points
+)
+
+// Team represents a group of players competing together
+type Team struct {
+	ID   string
+	Name string
+}
+
+// TeamSettings holds the team mode configuration of a room
+type TeamSettings struct {
+	Enabled bool
+	Assign  string
+	Scoring string
+	Teams   []*Team
+
+	departed map[string]*teamTally // points of players who left, by team ID
+}
+
+// teamTally adds up the points and number of players counted for a team
+type teamTally struct {
+	points  int
+	members int
+}
+
+type TeamScore struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Points  int    `json:"points"`
+	Members int    `json:"members"`
+}
+
+// configureTeams replaces the room's team settings and clears previous
+// assignments, only allowed while in the lobby
+func configureTeams(room *Room, config TeamConfig) error {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if gameStarted(room) {
+		return eventError(ERR_WRONG_STATE, "teams can only be configured before the game starts")
+	}
+
+	if !config.Enabled {
+		room.Teams = TeamSettings{}
+		for _, p := range room.Players {
+			p.TeamID = ""
+		}
+		return nil
+	}
+
+	if len(config.Teams) < 2 {
+		return eventError(ERR_INVALID, "team mode requires at least 2 teams")
+	}
+
+	assign := config.Assign
+	if assign == "" {
+		assign = TEAM_ASSIGN_MANUAL
+	}
+	if assign != TEAM_ASSIGN_MANUAL && assign != TEAM_ASSIGN_AUTO {
+		return eventError(ERR_INVALID, "unknown team assignment mode %q", config.Assign)
+	}
+
+	scoring := config.Scoring
+	if scoring == "" {
+		scoring = TEAM_SCORE_SUM
+	}
+	if scoring != TEAM_SCORE_SUM && scoring != TEAM_SCORE_AVERAGE {
+		return eventError(ERR_INVALID, "unknown team scoring mode %q", config.Scoring)
+	}
+
+	teams := make([]*Team, 0, len(config.Teams))
+	for i, name := range config.Teams {
+		if len(name) == 0 {
+			return eventError(ERR_INVALID, "team %d has no name", i+1)
+		}
+		teams = append(teams, &Team{
+			ID:   fmt.Sprintf("team-%d", i+1),
+			Name: name,
+		})
+	}
+
+	room.Teams = TeamSettings{
+		Enabled: true,
+		Assign:  assign,
+		Scoring: scoring,
+		Teams:   teams,
+	}
+
+	for _, p := range room.Players {
+		p.TeamID = ""
+		if assign == TEAM_ASSIGN_AUTO {
+			p.TeamID = smallestTeam(room)
+		}
+	}
+
+	return nil
+}
+
+// assignTeam places a player in a team of the room. The caller holds the room's lock
+func assignTeam(room *Room, playerID string, teamID string) error {
+	if !room.Teams.Enabled {
+		return eventError(ERR_TEAMS_DISABLED, "teams are not enabled in room %s", room.ID)
+	}
+
+	if findTeam(room, teamID) == nil {
+		return eventError(ERR_NOT_FOUND, "team %s not found in room %s", teamID, room.ID)
+	}
+
+	for _, p := range room.Players {
+		if p.ID == playerID {
+			p.TeamID = teamID
+			return nil
+		}
+	}
+
+	return eventError(ERR_NOT_FOUND, "player %s not found in room %s", playerID, room.ID)
+}
+
+// chooseTeam places a player in the team they picked, only allowed in the
+// lobby of a room whose teams are assigned manually
+func chooseTeam(room *Room, playerID string, teamID string) error {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if !room.Teams.Enabled {
+		return eventError(ERR_TEAMS_DISABLED, "teams are not enabled in room %s", room.ID)
+	}
+
+	if room.Teams.Assign != TEAM_ASSIGN_MANUAL {
+		return eventError(ERR_NOT_ALLOWED, "teams in room %s are assigned automatically", room.ID)
+	}
+
+	if gameStarted(room) {
+		return eventError(ERR_WRONG_STATE, "teams cannot be changed after the game starts")
+	}
+
+	return assignTeam(room, playerID, teamID)
+}
+
+func findTeam(room *Room, teamID string) *Team {
+	for _, t := range room.Teams.Teams {
+		if t.ID == teamID {
+			return t
+		}
+	}
+	return nil
+}
+
+// smallestTeam returns the ID of the team with the fewest members. The caller
+// holds the room's lock
+func smallestTeam(room *Room) string {
+	if len(room.Teams.Teams) == 0 {
+		return ""
+	}
+
+	counts := make(map[string]int)
+	for _, p := range room.Players {
+		counts[p.TeamID]++
+	}
+
+	best := room.Teams.Teams[0].ID
+	for _, t := range room.Teams.Teams[1:] {
+		if counts[t.ID] < counts[best] {
+			best = t.ID
+		}
+	}
+	return best
+}
+
+// teamsEnabled reports whether the room is in team mode
+func teamsEnabled(room *Room) bool {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	return room.Teams.Enabled
+}
+
+// keepTeamPoints credits the points of a player leaving the room to their
+// team so the team does not lose them. Callers hold room.mu
+func keepTeamPoints(room *Room, player *Player) {
+	if !room.Teams.Enabled || player.TeamID == "" {
+		return
+	}
+
+	if room.Teams.departed == nil {
+		room.Teams.departed = make(map[string]*teamTally)
+	}
+	tally, ok := room.Teams.departed[player.TeamID]
+	if !ok {
+		tally = &teamTally{}
+		room.Teams.departed[player.TeamID] = tally
+	}
+	tally.points += player.Points
+	tally.members++
+}
+
+// teamScores computes team standings sorted by points in descending order.
+// Points of players who left still count for their team, and for averages
+// they count as members. Members only reports the players still in the room
+func teamScores(room *Room) []TeamScore {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if !room.Teams.Enabled {
+		return nil
+	}
+
+	totals := make(map[string]*teamTally)
+	members := make(map[string]int)
+	tally := func(teamID string) *teamTally {
+		t, ok := totals[teamID]
+		if !ok {
+			t = &teamTally{}
+			totals[teamID] = t
+		}
+		return t
+	}
+
+	for _, p := range room.Players {
+		if p.TeamID == "" {
+			continue
+		}
+		t := tally(p.TeamID)
+		t.points += p.Points
+		t.members++
+		members[p.TeamID]++
+	}
+	for teamID, departed := range room.Teams.departed {
+		t := tally(teamID)
+		t.points += departed.points
+		t.members += departed.members
+	}
+
+	scores := make([]TeamScore, 0, len(room.Teams.Teams))
+	for _, team := range room.Teams.Teams {
+		points := 0
+		if t, ok := totals[team.ID]; ok {
+			points = t.points
+			if room.Teams.Scoring == TEAM_SCORE_AVERAGE && t.members > 0 {
+				points = points / t.members
+			}
+		}
+
+		scores = append(scores, TeamScore{
+			ID:      team.ID,
+			Name:    team.Name,
+			Points:  points,
+			Members: members[team.ID],
+		})
+	}
+
+	sort.SliceStable(scores, func(i, j int) bool {
+		return scores[i].Points > scores[j].Points
+	})
+
+	return scores
+}
+
+// teamRoster lists every team with the IDs of its members
+func teamRoster(room *Room) TeamUpdate {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	update := TeamUpdate{
+		Assign:  room.Teams.Assign,
+		Scoring: room.Teams.Scoring,
+		Teams:   make([]TeamMembers, 0, len(room.Teams.Teams)),
+	}
+
+	for _, t := range room.Teams.Teams {
+		members := TeamMembers{
+			ID:        t.ID,
+			Name:      t.Name,
+			PlayerIDs: []string{},
+		}
+		for _, p := range room.Players {
+			if p.TeamID == t.ID {
+				members.PlayerIDs = append(members.PlayerIDs, p.ID)
+			}
+		}
+		update.Teams = append(update.Teams, members)
+	}
+
+	return update
+}
+
+// broadcastTeams sends the current team roster to the host and every player
+func broadcastTeams(b *Broadcaster, room *Room) error {
+	e := &Event[TeamUpdate]{
+		Event:   EVENT_TEAM_UPDATE,
+		Content: teamRoster(room),
+	}
+
+	return b.BroadcastAll(room, e)
+}
@@ -1,6 +1,8 @@
 package ws
 
 import (
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/coder/websocket"
@@ -8,24 +10,46 @@ import (
 
 // Player represents a connected player
 type Player struct {
-	ID     string
-	Points int
-	Conn   *websocket.Conn
+	ID          string // generated by the server
+	Nickname    string // display name chosen by the player
+	SessionID   string // optional client session, used to enforce bans
+	AnswersFrom int    // index of the first question the player may answer
+	Points      int
+	TeamID      string
+	Conn        *websocket.Conn
+
+	Disconnected atomic.Bool // set once the player stops answering heartbeats
 }
 
 type PlayerScore struct {
-	ID     string `json:"id"`
-	Points int    `json:"points"`
+	ID       string `json:"id"`
+	Nickname string `json:"nickname"`
+	Points   int    `json:"points"`
+	TeamID   string `json:"teamId,omitempty"`
 }
 
 // Room represents a game room with connected players
 type Room struct {
-	ID       string
-	Players  []*Player
-	HostConn *websocket.Conn
-	Bank     *Bank
-	Question QuestionState
-	Skip     SkipControl
+	ID          string
+	OwnerID     int // host account that created the room
+	GameID      int // recorded game, set once the game starts
+	Players     []*Player
+	Locked      bool // no new players can join
+	MaxPlayers  int  // 0 means unlimited
+	LateJoin    string
+	HostConn    *websocket.Conn
+	Spectators  []*Spectator  // read-only connections following the host's view
+	OwnerToken  string        // authenticates controllers of the room
+	Controllers []*Controller // remote controls of the game flow
+	Bank        *Bank
+	Question    QuestionState
+	Skip        SkipControl
+	Teams       TeamSettings
+	Bans        BanList
+	Events      *EventLog // events sent to the room, for clients to catch up
+
+	mu      sync.Mutex  // guards Players and their teams, Spectators, Controllers, Teams and Bans
+	closing atomic.Bool // set once Shutdown owns closing the room
 }
 
 // QuestionState maintains the current state of a question
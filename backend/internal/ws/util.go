@@ -1,10 +1,24 @@
 package ws
 
-import "strings"
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
 
-func sanitizeRoomCode(c string) string {
-	c = strings.ToLower(c)
-	c = strings.ReplaceAll(c, " ", "")
+// generatePlayerID returns a random identifier for a player, independent of their nickname
+func generatePlayerID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
 
-	return c
+// generateToken returns a random secret suitable for authenticating room owners
+func generateToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
 }
@@ -17,7 +17,8 @@ type WebSocketContextData = {
   state: EventType;
 };
 
-const SUBPROTOCOL = 'kahoot';
+// newest protocol version first, the bare name is the original protocol
+const SUBPROTOCOLS = ['kahoot.v1', 'kahoot'];
 const HOST_URL = 'ws://localhost:3000/host';
 
 const WebSocketContext = createContext<WebSocketContextData>(
@@ -46,7 +47,7 @@ export const WebSocketContextProvider = ({
 
   useEffect(() => {
     setLoading(true);
-    webSocket.current = new WebSocket(HOST_URL, [SUBPROTOCOL]);
+    webSocket.current = new WebSocket(HOST_URL, SUBPROTOCOLS);
 
     webSocket.current.onopen = (e) => {
       setLoading(false);